@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//adminCacheTTL 群组管理员列表缓存时间, 管理员变动后最多延迟这么久生效
+const adminCacheTTL = 10 * time.Minute
+
+type adminEntry struct {
+	ids map[int]bool
+	at  time.Time
+}
+
+//AdminCache 按Chat.ID缓存群组管理员, 查询失败不缓存
+type AdminCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]*adminEntry
+	fetch   func(chatID int64) ([]int, error)
+}
+
+//NewAdminCache create NewAdminCache
+func NewAdminCache(ttl time.Duration, fetch func(chatID int64) ([]int, error)) *AdminCache {
+	return &AdminCache{
+		ttl:     ttl,
+		entries: make(map[int64]*adminEntry),
+		fetch:   fetch,
+	}
+}
+
+//IsAdmin userID是否为群组管理员
+func (c *AdminCache) IsAdmin(chatID int64, userID int) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e := c.entries[chatID]
+	c.mu.Unlock()
+	if e != nil && now.Sub(e.at) <= c.ttl {
+		return e.ids[userID], nil
+	}
+
+	ids, err := c.fetch(chatID)
+	if err != nil {
+		return false, err
+	}
+	e = &adminEntry{ids: make(map[int]bool, len(ids)), at: now}
+	for _, id := range ids {
+		e.ids[id] = true
+	}
+	c.mu.Lock()
+	c.entries[chatID] = e
+	c.mu.Unlock()
+	return e.ids[userID], nil
+}
+
+//Forget 丢弃缓存, 下次检查时重新查询
+func (c *AdminCache) Forget(chatID int64) {
+	c.mu.Lock()
+	delete(c.entries, chatID)
+	c.mu.Unlock()
+}
+
+//fetchAdmins 通过bot查询群组管理员(包括群主)
+func fetchAdmins(chatID int64) ([]int, error) {
+	members, err := bot.GetChatAdministrators(&tb.Chat{ID: chatID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.User.ID)
+	}
+	return ids, nil
+}
+
+var admins = NewAdminCache(adminCacheTTL, fetchAdmins)
+
+//canManage 是否可以修改聊天的订阅和设置: 私聊、机器人管理员、群组管理员, 或群组设置了允许所有成员
+func canManage(chat tb.Chat, user tb.User) (bool, error) {
+	if !chat.IsGroupChat() || isOwner(user) {
+		return true, nil
+	}
+	s, err := chatSettings.LoadChatSettings(chat.ID)
+	if err != nil {
+		return false, err
+	}
+	if s.AllowMembers {
+		return true, nil
+	}
+	return admins.IsAdmin(chat.ID, user.ID)
+}
+
+//callbackAllowed 按钮回调的权限检查, 无权限时以弹窗提示
+func callbackAllowed(cb tb.Callback, lang string, response *tb.CallbackResponse) bool {
+	ok, err := canManage(cb.Message.Chat, cb.Sender)
+	if err != nil {
+		log.Error("check chat admin failed.", err)
+		response.Text = T(lang, "error.query")
+		return false
+	}
+	if !ok {
+		response.Text = T(lang, "error.admin")
+		response.ShowAlert = true
+	}
+	return ok
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tb "tg.robot/telebot"
+)
+
+func TestAdminCache(t *testing.T) {
+	calls := 0
+	fail := true
+	cache := NewAdminCache(time.Minute, func(chatID int64) ([]int, error) {
+		calls++
+		if fail {
+			return nil, errors.New("timeout")
+		}
+		return []int{1, 2}, nil
+	})
+
+	if _, err := cache.IsAdmin(-100, 1); err == nil {
+		t.Fatal("fetch error should be returned")
+	}
+	fail = false
+	if ok, err := cache.IsAdmin(-100, 1); err != nil || !ok {
+		t.Fatalf("user 1 should be admin, %v", err)
+	}
+	if ok, _ := cache.IsAdmin(-100, 3); ok {
+		t.Fatal("user 3 should not be admin")
+	}
+	if calls != 2 {
+		t.Fatalf("errors should not be cached and results should, %d calls", calls)
+	}
+	cache.Forget(-100)
+	cache.IsAdmin(-100, 1)
+	if calls != 3 {
+		t.Fatalf("forget should refetch, %d calls", calls)
+	}
+}
+
+func TestCanManagePrivate(t *testing.T) {
+	if ok, err := canManage(tb.Chat{ID: 42, Type: tb.ChatPrivate}, tb.User{ID: 42}); err != nil || !ok {
+		t.Fatal("private chats need no admin check")
+	}
+}
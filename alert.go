@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//订阅类型
+const (
+	//SubTypeReport 定时推送行情
+	SubTypeReport = 1
+	//SubTypeRange78 BTC/BCH七上八下大波动提醒
+	SubTypeRange78 = 2
+	//SubTypePortfolioLevel 持仓总值穿越指定价位
+	SubTypePortfolioLevel = 3
+	//SubTypePortfolioMove 持仓总值当日涨跌超过百分比
+	SubTypePortfolioMove = 4
+	//SubTypePortfolioDigest 每日持仓盈亏摘要
+	SubTypePortfolioDigest = 5
+	//SubTypePriceLevel 交易对参考价穿越指定价位
+	SubTypePriceLevel = 6
+	//SubTypePriceMove 交易对参考价相对上次提醒涨跌超过百分比
+	SubTypePriceMove = 7
+)
+
+//alertWorkers 同时执行提醒的worker数量
+const alertWorkers = 4
+
+//alertRetryDelay 行情查询失败后重试的间隔(秒)
+const alertRetryDelay = 30
+
+var scheduler *Scheduler
+var outbox *Outbox
+
+//scheduleSubscription 按订阅的LastTime和Duration安排下次提醒
+func scheduleSubscription(key string, sub Subscription) {
+	if sub.Disabled {
+		return
+	}
+	scheduler.Schedule(key, time.Unix(int64(sub.LastTime+sub.Duration+1), 0))
+}
+
+//alertRun 一次提醒产生的消息和对订阅的修改, 修改在消息全部进入投递队列后才保存
+type alertRun struct {
+	msgs    []alertMessage
+	updates []func(sub *Subscription)
+}
+
+//deliver 发送HTML消息, urgent的消息在免打扰期间照常通知
+func (r *alertRun) deliver(msg string, urgent bool) {
+	r.msgs = append(r.msgs, alertMessage{msg, urgent})
+}
+
+//update 修改订阅中的基准价等状态, 投递失败重试时不会生效
+func (r *alertRun) update(fn func(sub *Subscription)) {
+	r.updates = append(r.updates, fn)
+}
+
+//alertFunc 执行一种订阅提醒, 行情查询失败时返回false, 稍后重试
+type alertFunc func(k string, sub Subscription, run *alertRun) bool
+
+var alertFuncs = map[int]alertFunc{
+	SubTypeReport:          alertReport,
+	SubTypeRange78:         alertRange78,
+	SubTypePortfolioLevel:  alertPortfolioLevel,
+	SubTypePortfolioMove:   alertPortfolioMove,
+	SubTypePortfolioDigest: alertPortfolioDigest,
+	SubTypePriceLevel:      alertPriceLevel,
+	SubTypePriceMove:       alertPriceMove,
+}
+
+//runAlert 执行一次到期的订阅提醒并安排下一次
+func runAlert(k string) {
+	sub, ok := subscriptions.Get(k)
+	if !ok || sub.Disabled {
+		return
+	}
+	currentTime := LocalSecond()
+	if currentTime-sub.LastTime <= sub.Duration {
+		scheduleSubscription(k, sub)
+		return
+	}
+	fn, ok := alertFuncs[sub.Type]
+	if !ok {
+		log.Error("unknown subscription type %d of %s", sub.Type, k)
+		return
+	}
+
+	run := &alertRun{}
+	if !fn(k, sub, run) {
+		scheduler.Schedule(k, time.Now().Add(alertRetryDelay*time.Second))
+		return
+	}
+
+	if err := notifyAll(sub.Recipient(), k, run.msgs); err != nil {
+		//消息全部没有进入投递队列, 不推进LastTime和基准价, 稍后重试
+		log.Error("queue alert failed.", err)
+		scheduler.Schedule(k, time.Now().Add(alertRetryDelay*time.Second))
+		return
+	}
+	updateSubscription(k, func(s *Subscription) {
+		//提醒可以修改LastTime, 例如每日摘要固定在设置的时间发送
+		s.LastTime = currentTime
+		for _, fn := range run.updates {
+			fn(s)
+		}
+	})
+	if sub, ok = subscriptions.Get(k); ok {
+		scheduleSubscription(k, sub)
+	}
+}
+
+func alertReport(k string, sub Subscription, run *alertRun) bool {
+	lang := chatLang(sub.ChatID, nil)
+	var msg string
+	ok := true
+	if _, known := compareSources(sub.Trader); known {
+		msg, ok = compareReport(sub.Trader, sub.ChatID, lang)
+	} else if sub.Trader == COINEX {
+		msg, ok = exchangeReport(COINEX, sub.ChatID, lang)
+	} else {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	run.deliver(msg, false)
+	return true
+}
+
+func alertRange78(k string, sub Subscription, run *alertRun) bool {
+	btcm := bitstamp("btcusd", BTC)
+	bchm := bitstamp("bchusd", BCH)
+	if HasNull(bchm, btcm) {
+		return false
+	}
+	lang := chatLang(sub.ChatID, nil)
+	if sub.BTCPrice > 0 && sub.BCHPrice > 0 {
+
+		btcPercentChange := (btcm.Last - sub.BTCPrice) / btcm.Last
+		bchPercentChange := (bchm.Last - sub.BCHPrice) / bchm.Last
+
+		if btcPercentChange >= 0.07 || btcPercentChange <= -0.08 {
+
+			msg := T(lang, "alert.fall", BTC, sub.BTCPrice, btcm.Last, btcPercentChange*100)
+			if btcPercentChange > 0 {
+				msg = T(lang, "alert.rise", BTC, sub.BTCPrice, btcm.Last, btcPercentChange*100)
+
+			}
+			run.deliver(trendMark(btcPercentChange)+" "+escapeHTML(msg), true)
+			run.update(func(s *Subscription) { s.BTCPrice = btcm.Last })
+
+			run.deliver(compareText(BTC, sub.ChatID, lang), true)
+		}
+		if bchPercentChange >= 0.07 || bchPercentChange <= -0.08 {
+
+			msg := T(lang, "alert.fall", BCH, sub.BCHPrice, bchm.Last, bchPercentChange*100)
+			if bchPercentChange > 0 {
+				msg = T(lang, "alert.rise", BCH, sub.BCHPrice, bchm.Last, bchPercentChange*100)
+
+			}
+			log.Info(msg)
+			run.deliver(trendMark(bchPercentChange)+" "+escapeHTML(msg), true)
+			run.update(func(s *Subscription) { s.BCHPrice = bchm.Last })
+			run.deliver(compareText(BCH, sub.ChatID, lang), true)
+		}
+	} else {
+		run.update(func(s *Subscription) {
+			s.BCHPrice = bchm.Last
+			s.BTCPrice = btcm.Last
+		})
+		msg := T(lang, "alert.range78.sub", btcm.Last, bchm.Last)
+		log.Info(msg)
+		run.deliver(escapeHTML(msg), true)
+	}
+	return true
+}
+
+//alertPriceLevel 参考价从上次检查时的一侧穿越到Level另一侧时提醒
+func alertPriceLevel(k string, sub Subscription, run *alertRun) bool {
+	price, err := referencePrice(sub.Trader)
+	if err != nil {
+		return false
+	}
+	if sub.Baseline > 0 && crossed(sub.Baseline, price, sub.Level) {
+		lang := chatLang(sub.ChatID, nil)
+		msg := T(lang, "alert.level.below", sub.Trader, formatPrice(sub.Level), formatPrice(sub.Baseline), formatPrice(price))
+		if price > sub.Baseline {
+			msg = T(lang, "alert.level.above", sub.Trader, formatPrice(sub.Level), formatPrice(sub.Baseline), formatPrice(price))
+		}
+		log.Info(msg)
+		run.deliver(trendMark(price-sub.Baseline)+" "+escapeHTML(msg), true)
+	}
+	run.update(func(s *Subscription) { s.Baseline = price })
+	return true
+}
+
+//alertPriceMove 参考价相对上次提醒涨跌超过Percent时提醒
+func alertPriceMove(k string, sub Subscription, run *alertRun) bool {
+	price, err := referencePrice(sub.Trader)
+	if err != nil {
+		return false
+	}
+	if sub.Baseline <= 0 {
+		run.update(func(s *Subscription) { s.Baseline = price })
+		return true
+	}
+	change := (price - sub.Baseline) / sub.Baseline * 100
+	if math.Abs(change) >= sub.Percent {
+		msg := T(chatLang(sub.ChatID, nil), "alert.move", sub.Trader, formatPrice(sub.Baseline), formatPrice(price), change)
+		log.Info(msg)
+		run.deliver(trendMark(change)+" "+escapeHTML(msg), true)
+		run.update(func(s *Subscription) { s.Baseline = price })
+	}
+	return true
+}
+
+//subscribeReport 订阅每小时行情推送
+func subscribeReport(chatID int64, trader string) {
+	ns := NewSubscription(trader, SubTypeReport, 3600)
+	ns.ChatID = chatID
+	addSubscription(subscriptionKey(trader, chatID), ns)
+}
+
+//reportAlertHandler /alertbtc 等每小时推送行情的订阅
+func reportAlertHandler(trader string) CommandHandler {
+	return func(message tb.Message, args []string) {
+		subscribeReport(message.Chat.ID, trader)
+		msg := T(messageLang(message), "alert.sub."+trader)
+		log.Info(msg)
+		bot.SendMessage(message.Chat, msg, nil)
+	}
+}
+
+//deleteAlertHandler /dalertbtc 等取消订阅, key为回复的消息ID
+func deleteAlertHandler(prefix string, key string) CommandHandler {
+	return func(message tb.Message, args []string) {
+		deleteSubscription(subscriptionKey(prefix, message.Chat.ID))
+		msg := T(messageLang(message), key)
+		log.Info(msg)
+		bot.SendMessage(message.Chat, msg, nil)
+	}
+}
+
+//doAlertRange78 订阅BTC/BCH七上八下大波动提醒
+func doAlertRange78(message tb.Message, args []string) {
+	lang := messageLang(message)
+	key := subscriptionKey(BTC+BCH, message.Chat.ID)
+	ns := NewSubscription(BCH, SubTypeRange78, 600)
+	ns.ChatID = message.Chat.ID
+
+	btcm := bitstamp("btcusd", BTC)
+	bchm := bitstamp("bchusd", BCH)
+	if HasNull(btcm, bchm) {
+		bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+		return
+	}
+	ns.BTCPrice = btcm.Last
+	ns.BCHPrice = bchm.Last
+	addSubscription(key, ns)
+
+	msg := T(lang, "alert.range78.sub", btcm.Last, bchm.Last)
+	log.Info(msg)
+	bot.SendMessage(message.Chat, msg, nil)
+}
+
+//alert 启动订阅提醒调度, 并定时发送免打扰结束后的摘要
+func alert() {
+	for k, sub := range subscriptions.List() {
+		scheduleSubscription(k, sub)
+	}
+	go scheduler.Start(nil)
+
+	for range time.Tick(30 * time.Second) {
+		flushDigest()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	tb "tg.robot/telebot"
+)
+
+const (
+	//callbackDataLimit Telegram限制callback_data最多64字节
+	callbackDataLimit = 64
+	//callbackSigSize 签名截取的字节数
+	callbackSigSize = 8
+	callbackArgSep  = ":"
+	callbackSigSep  = "~"
+)
+
+//ErrBadCallback 回调数据格式错误或签名不匹配
+var ErrBadCallback = errors.New("bad callback data")
+
+//CallbackHandler 处理按钮回调, 通过response设置回复给用户的提示
+type CallbackHandler func(cb tb.Callback, args []string, response *tb.CallbackResponse)
+
+//callbackRoute 注册的action和参数个数
+type callbackRoute struct {
+	args    int
+	handler CallbackHandler
+}
+
+//CallbackRouter 按action分发按钮回调, callback_data为 action:arg:arg~签名
+type CallbackRouter struct {
+	routes map[string]callbackRoute
+	key    []byte
+	//Limiter 与命令共用的频率限制, 为nil时不限制
+	Limiter *RateLimiter
+}
+
+//NewCallbackRouter create NewCallbackRouter
+func NewCallbackRouter() *CallbackRouter {
+	return &CallbackRouter{routes: make(map[string]callbackRoute)}
+}
+
+//SetKey 设置签名密钥, 由bot token派生, 重启后旧按钮仍然有效
+func (r *CallbackRouter) SetKey(secret string) {
+	sum := sha256.Sum256([]byte("callback:" + secret))
+	r.key = sum[:]
+}
+
+//Handle 注册action, args为参数个数, 参数个数不符的回调不会交给handler, 重复时panic
+func (r *CallbackRouter) Handle(action string, args int, handler CallbackHandler) {
+	if _, ok := r.routes[action]; ok || strings.Contains(action, callbackArgSep) {
+		panic("bad callback action " + action)
+	}
+	r.routes[action] = callbackRoute{args: args, handler: handler}
+}
+
+func (r *CallbackRouter) sign(payload string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSigSize])
+}
+
+//Data 生成按钮的callback_data, 参数不能包含分隔符
+func (r *CallbackRouter) Data(action string, args ...string) string {
+	payload := strings.Join(append([]string{action}, args...), callbackArgSep)
+	data := payload + callbackSigSep + r.sign(payload)
+	if len(data) > callbackDataLimit || strings.Contains(payload, callbackSigSep) {
+		log.Error("bad callback data %q", data)
+	}
+	return data
+}
+
+//Decode 校验签名并解析callback_data
+func (r *CallbackRouter) Decode(data string) (string, []string, error) {
+	i := strings.LastIndex(data, callbackSigSep)
+	if i < 0 || len(data) > callbackDataLimit {
+		return "", nil, ErrBadCallback
+	}
+	payload, sig := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(r.sign(payload))) {
+		return "", nil, ErrBadCallback
+	}
+	fields := strings.Split(payload, callbackArgSep)
+	return fields[0], fields[1:], nil
+}
+
+//Dispatch 处理按钮回调, 无论成功与否都应答, 否则客户端会一直显示加载
+func (r *CallbackRouter) Dispatch(cb tb.Callback) {
+	response := &tb.CallbackResponse{}
+	defer func() {
+		if err := bot.AnswerCallbackQuery(&cb, response); err != nil {
+			log.Error("answer callback failed.", err)
+		}
+	}()
+
+	action, args, err := r.Decode(cb.Data)
+	route, ok := r.routes[action]
+	if err != nil || !ok || len(args) != route.args {
+		log.Info("reject callback %q from %d", cb.Data, cb.Sender.ID)
+		response.Text = T(chatLang(cb.Message.Chat.ID, &cb.Sender), "error.callback")
+		return
+	}
+	if r.Limiter != nil {
+		//刷新和交易所按钮同样会查询全部交易所, 与命令共用令牌桶
+		if ok, retry, _ := r.Limiter.Allow(tb.Message{Chat: cb.Message.Chat, Sender: cb.Sender}, time.Now()); !ok {
+			log.Info("rate limit callback %q from %d", action, cb.Sender.ID)
+			response.Text = T(chatLang(cb.Message.Chat.ID, &cb.Sender), "ratelimit", int(math.Ceil(retry.Seconds())))
+			return
+		}
+	}
+	route.handler(cb, args, response)
+}
+
+var callbacks = NewCallbackRouter()
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallbackRouterDecode(t *testing.T) {
+	r := NewCallbackRouter()
+	r.SetKey("123:token")
+	data := r.Data(cbExchange, BITFINEX, BCHBTC)
+	if len(data) > callbackDataLimit || !strings.HasPrefix(data, "x:Bitfinex:BCHBTC~") {
+		t.Fatalf("data %q", data)
+	}
+	action, args, err := r.Decode(data)
+	if err != nil || action != cbExchange || len(args) != 2 || args[1] != BCHBTC {
+		t.Fatalf("decode %q %v %v", action, args, err)
+	}
+
+	sig := data[strings.LastIndex(data, callbackSigSep):]
+	for _, bad := range []string{
+		"x:Bitfinex:BTC" + sig,
+		"x:Bitfinex:BCHBTC",
+		data + "x",
+		"",
+	} {
+		if _, _, err = r.Decode(bad); err != ErrBadCallback {
+			t.Fatalf("%q should be rejected, got %v", bad, err)
+		}
+	}
+
+	other := NewCallbackRouter()
+	other.SetKey("456:token")
+	if _, _, err = other.Decode(data); err != ErrBadCallback {
+		t.Fatal("data signed with another key should be rejected")
+	}
+}
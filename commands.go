@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//Permission 命令权限
+type Permission int
+
+const (
+	//PermAll 所有人可用
+	PermAll Permission = iota
+	//PermOwner 仅 app.owners 可用
+	PermOwner
+	//PermAdmin 群组中仅管理员可用, 聊天设置允许所有成员时不限制
+	PermAdmin
+)
+
+//CommandArg 命令参数说明
+type CommandArg struct {
+	Name     string
+	Optional bool
+}
+
+//CommandHandler 处理命令, args为命令名之后按空白分隔的参数
+type CommandHandler func(message tb.Message, args []string)
+
+//Command 命令定义
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Args        []CommandArg
+	Permission  Permission
+	Handler     CommandHandler
+}
+
+//Usage 命令用法, 例如 /convert <amount> <from> <to>
+func (c *Command) Usage() string {
+	str := "/" + c.Name
+	for _, a := range c.Args {
+		if a.Optional {
+			str = fmt.Sprintf("%s [%s]", str, a.Name)
+		} else {
+			str = fmt.Sprintf("%s <%s>", str, a.Name)
+		}
+	}
+	return str
+}
+
+//required 必填参数个数
+func (c *Command) required() int {
+	n := 0
+	for _, a := range c.Args {
+		if !a.Optional {
+			n++
+		}
+	}
+	return n
+}
+
+//suggestDistance 未知命令与已有命令的编辑距离不超过该值时提示
+const suggestDistance = 2
+
+//CommandRegistry 命令注册表, 按注册顺序列出命令
+type CommandRegistry struct {
+	commands []*Command
+	names    map[string]*Command
+	//Fallback 私聊中无法识别的消息的回复, 为空时使用消息目录中的fallback, off为不回复
+	Fallback string
+	//Limiter 命令频率限制, 为nil时不限制
+	Limiter *RateLimiter
+}
+
+//NewCommandRegistry create NewCommandRegistry
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{names: make(map[string]*Command)}
+}
+
+//Register 注册命令, 名称或别名重复时panic
+func (r *CommandRegistry) Register(c *Command) {
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		name = strings.ToLower(name)
+		if _, ok := r.names[name]; ok {
+			panic("duplicate command /" + name)
+		}
+		r.names[name] = c
+	}
+	r.commands = append(r.commands, c)
+}
+
+//Lookup 按名称或别名查找命令
+func (r *CommandRegistry) Lookup(name string) (*Command, bool) {
+	c, ok := r.names[strings.ToLower(name)]
+	return c, ok
+}
+
+//Commands 全部命令
+func (r *CommandRegistry) Commands() []*Command {
+	return r.commands
+}
+
+//levenshtein 编辑距离
+func levenshtein(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+//Suggest 与name编辑距离最近的命令(包括别名), 距离相同时取先注册的
+func (r *CommandRegistry) Suggest(name string) (*Command, bool) {
+	var best *Command
+	bestDistance := suggestDistance + 1
+	for _, c := range r.commands {
+		for _, n := range append([]string{c.Name}, c.Aliases...) {
+			d := levenshtein(name, n)
+			if d < bestDistance && d < len([]rune(name)) {
+				best, bestDistance = c, d
+			}
+		}
+	}
+	return best, best != nil
+}
+
+//BotCommands Telegram客户端菜单中的命令, 不包括管理员命令
+func (r *CommandRegistry) BotCommands() []tb.BotCommand {
+	list := make([]tb.BotCommand, 0, len(r.commands))
+	for _, c := range r.commands {
+		if c.Permission == PermOwner {
+			continue
+		}
+		list = append(list, tb.BotCommand{Command: c.Name, Description: c.Description})
+	}
+	return list
+}
+
+//HelpText 命令列表, 管理员可以看到管理命令, 没有翻译的命令使用Description
+func (r *CommandRegistry) HelpText(lang string, owner bool) string {
+	lines := make([]string, 0, len(r.commands))
+	for _, c := range r.commands {
+		if c.Permission == PermOwner && !owner {
+			continue
+		}
+		desc, ok := catalogs[lang]["cmd."+c.Name]
+		if !ok {
+			desc = c.Description
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", c.Usage(), desc))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//parseCommand 解析 /cmd@bot args, forUs为false表示命令@了其他机器人, mentioned表示命令明确@了本机器人
+func parseCommand(text string, botName string) (name string, args []string, forUs bool, mentioned bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil, true, false
+	}
+	name = strings.TrimPrefix(fields[0], "/")
+	if i := strings.Index(name, "@"); i >= 0 {
+		if !strings.EqualFold(name[i+1:], botName) {
+			return "", nil, false, false
+		}
+		name = name[:i]
+		mentioned = true
+	}
+	return strings.ToLower(name), fields[1:], true, mentioned
+}
+
+//allow 检查频率限制, 超过时第一次拒绝回复需要等待的秒数
+func (r *CommandRegistry) allow(message tb.Message, lang string) bool {
+	if r.Limiter == nil {
+		return true
+	}
+	ok, retry, warn := r.Limiter.Allow(message, time.Now())
+	if ok {
+		return true
+	}
+	log.Info("rate limit %q from %d in %d", message.Text, message.Sender.ID, message.Chat.ID)
+	if warn {
+		bot.SendMessage(message.Chat, T(lang, "ratelimit", int(math.Ceil(retry.Seconds()))), &tb.SendOptions{ReplyTo: message})
+	}
+	return false
+}
+
+//Dispatch 把消息交给对应命令处理
+func (r *CommandRegistry) Dispatch(message tb.Message, botName string) {
+	if strings.TrimSpace(message.Text) == "" {
+		return
+	}
+	name, args, forUs, mentioned := parseCommand(message.Text, botName)
+	if !forUs {
+		return
+	}
+	lang := messageLang(message)
+	c, ok := r.Lookup(name)
+	if !ok {
+		//群里的普通聊天和其他机器人的命令不回复, 只有明确@本机器人的命令才提示相近的命令
+		if name != "" && (mentioned || !message.Chat.IsGroupChat()) {
+			if s, ok := r.Suggest(name); ok {
+				if r.allow(message, lang) {
+					bot.SendMessage(message.Chat, T(lang, "suggest", s.Name), nil)
+				}
+				return
+			}
+		}
+		if !message.Chat.IsGroupChat() && r.Fallback != "off" && r.allow(message, lang) {
+			fallback := r.Fallback
+			if fallback == "" {
+				fallback = T(lang, "fallback")
+			}
+			bot.SendMessage(message.Chat, fallback, nil)
+		}
+		return
+	}
+	if !r.allow(message, lang) {
+		return
+	}
+	if c.Permission == PermOwner && !isOwner(message.Sender) {
+		bot.SendMessage(message.Chat, T(lang, "error.permission"), nil)
+		return
+	}
+	if c.Permission == PermAdmin {
+		ok, err := canManage(message.Chat, message.Sender)
+		if err != nil {
+			log.Error("check chat admin failed.", err)
+			bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+			return
+		}
+		if !ok {
+			bot.SendMessage(message.Chat, T(lang, "error.admin"), &tb.SendOptions{ReplyTo: message})
+			return
+		}
+	}
+	if len(args) < c.required() {
+		bot.SendMessage(message.Chat, T(lang, "usage", c.Usage()), nil)
+		return
+	}
+	log.Info("command /%s from %d in %d", c.Name, message.Sender.ID, message.Chat.ID)
+	stats.Command(c.Name)
+	c.Handler(message, args)
+}
+
+var commands = NewCommandRegistry()
+
+//chatHandler 只需要聊天、语言和参数的命令
+func chatHandler(fn func(chat *tb.Chat, lang string, args []string)) CommandHandler {
+	return func(message tb.Message, args []string) {
+		fn(&message.Chat, messageLang(message), args)
+	}
+}
+
+//textHandler 回复固定生成的行情文本
+func textHandler(text func(chatID int64, lang string) string) CommandHandler {
+	return func(message tb.Message, args []string) {
+		sendHTML(&message.Chat, text(message.Chat.ID, messageLang(message)))
+	}
+}
+
+//compareHandler 币种行情对比, 附带刷新、订阅和各交易所按钮
+func compareHandler(symbol string) CommandHandler {
+	return func(message tb.Message, args []string) {
+		lang := messageLang(message)
+		sendHTMLKeyboard(&message.Chat, compareText(symbol, message.Chat.ID, lang), priceKeyboard(symbol, lang))
+	}
+}
+
+func exchangeHandler(exchange string) CommandHandler {
+	return textHandler(func(chatID int64, lang string) string { return exchangeText(exchange, chatID, lang) })
+}
+
+func doHelp(message tb.Message, args []string) {
+	bot.SendMessage(message.Chat, commands.HelpText(messageLang(message), isOwner(message.Sender)), nil)
+}
+
+func doStart(message tb.Message, args []string) {
+	lang := messageLang(message)
+	msg := T(lang, "start", message.Sender.FirstName, commands.HelpText(lang, isOwner(message.Sender)))
+	bot.SendMessage(message.Chat, msg, nil)
+}
+
+func init() {
+	for _, c := range []*Command{
+		{Name: "start", Description: "start the bot and list commands", Handler: doStart},
+		{Name: "help", Description: "list commands", Handler: doHelp},
+		{Name: "hi", Description: "say hi to bot", Handler: func(message tb.Message, args []string) {
+			bot.SendMessage(message.Chat, T(messageLang(message), "hi", message.Sender.FirstName), nil)
+		}},
+		{Name: "btc", Description: "btc price", Handler: compareHandler(BTC)},
+		{Name: "bch", Description: "bch price", Handler: compareHandler(BCH)},
+		{Name: "ltc", Description: "ltc price", Handler: compareHandler(LTC)},
+		{Name: "eth", Description: "eth price", Handler: compareHandler(ETH)},
+		{Name: "bchbtc", Description: "bchbtc price", Handler: compareHandler(BCHBTC)},
+		{Name: "ltcbtc", Description: "ltcbtc price", Handler: compareHandler(LTCBTC)},
+		{Name: "ethbtc", Description: "ethbtc price", Handler: compareHandler(ETHBTC)},
+		{Name: "coinex", Description: "show all coinex price", Args: []CommandArg{{Name: "market", Optional: true}}, Handler: doCoinexCommand},
+		{Name: "bitstamp", Description: "show all bitstamp price", Handler: exchangeHandler(BITSTAMP)},
+		{Name: "poloniex", Description: "show all poloniex price", Handler: exchangeHandler(POLONIEX)},
+		{Name: "bittrex", Description: "show all bittrex price", Handler: exchangeHandler(BITTREX)},
+		{Name: "bitfinex", Description: "show all bitfinex price", Handler: exchangeHandler(BITFINEX)},
+		{Name: "binance", Description: "show all binance price", Handler: exchangeHandler(BINANCE)},
+		{Name: "convert", Aliases: []string{"cv"}, Description: "convert between coins, e.g. /convert 0.25 BTC ETH", Args: []CommandArg{{Name: "amount"}, {Name: "from"}, {Name: "to"}}, Handler: chatHandler(doConvert)},
+		{Name: "currency", Description: "display prices in a fiat currency or stablecoin, e.g. /currency CNY", Args: []CommandArg{{Name: "currency|off", Optional: true}}, Permission: PermAdmin, Handler: chatHandler(doCurrency)},
+		{Name: "lang", Description: "switch language, e.g. /lang en", Args: []CommandArg{{Name: "zh|en", Optional: true}}, Permission: PermAdmin, Handler: doLang},
+		{Name: "settings", Description: "chat settings: language, time zone, currency, exchanges and more", Args: []CommandArg{{Name: "timezone zone", Optional: true}}, Permission: PermAdmin, Handler: chatHandler(doSettings)},
+		{Name: "alertbtc", Description: "Subscription 1hour btc", Permission: PermAdmin, Handler: reportAlertHandler(BTC)},
+		{Name: "alertbch", Description: "Subscription 1hour bch", Permission: PermAdmin, Handler: reportAlertHandler(BCH)},
+		{Name: "alertcoinex", Description: "Subscription 1hour coinex", Permission: PermAdmin, Handler: reportAlertHandler(COINEX)},
+		{Name: "alertrange78", Description: "Subscription range78", Permission: PermAdmin, Handler: doAlertRange78},
+		{Name: "dalertbtc", Description: "unSubscription 1hour btc", Permission: PermAdmin, Handler: deleteAlertHandler(BTC, "alert.unsub.BTC")},
+		{Name: "dalertbch", Description: "unSubscription 1hour bch", Permission: PermAdmin, Handler: deleteAlertHandler(BCH, "alert.unsub.BCH")},
+		{Name: "dalertcoinex", Description: "unSubscription 1hour coinex", Permission: PermAdmin, Handler: deleteAlertHandler(COINEX, "alert.unsub.CoinEx")},
+		{Name: "dalertrange78", Description: "unSubscription range78", Permission: PermAdmin, Handler: deleteAlertHandler(BTC+BCH, "alert.range78.unsub")},
+		{Name: "newalert", Description: "set up a price level, move or scheduled alert step by step", Permission: PermAdmin, Handler: doNewAlert},
+		{Name: "cancel", Description: "cancel the current setup", Permission: PermAdmin, Handler: doCancel},
+		{Name: "alerts", Description: "list and remove alerts in this chat", Handler: doAlerts},
+		{Name: "quiet", Description: "set quiet hours, e.g. /quiet 23-7", Args: []CommandArg{{Name: "hours|off", Optional: true}}, Permission: PermAdmin, Handler: chatHandler(doQuiet)},
+		{Name: "mute", Description: "mute alerts, e.g. /mute 2h", Args: []CommandArg{{Name: "duration"}}, Permission: PermAdmin, Handler: chatHandler(doMute)},
+		{Name: "unmute", Description: "unmute alerts", Permission: PermAdmin, Handler: func(message tb.Message, args []string) { doUnmute(&message.Chat, messageLang(message)) }},
+		{Name: "hold", Description: "record holdings, e.g. /hold BTC 0.5 @ 30000", Args: []CommandArg{{Name: "symbol"}, {Name: "amount"}, {Name: "@ price", Optional: true}}, Handler: doHold},
+		{Name: "portfolio", Aliases: []string{"pf"}, Description: "show portfolio value and P/L", Handler: func(message tb.Message, args []string) { doPortfolio(message) }},
+		{Name: "palert", Description: "portfolio alert, e.g. /palert 50000 or /palert 5%", Args: []CommandArg{{Name: "level|percent|off"}}, Permission: PermAdmin, Handler: doPortfolioAlert},
+		{Name: "pdigest", Description: "daily portfolio digest, e.g. /pdigest on 9", Args: []CommandArg{{Name: "on|off"}, {Name: "hour", Optional: true}}, Permission: PermAdmin, Handler: doPortfolioDigest},
+		{Name: "subs", Description: "list all subscriptions", Permission: PermOwner, Handler: func(message tb.Message, args []string) { doSubs(message) }},
+		{Name: "stats", Description: "bot statistics: chats, subscriptions, commands and exchange errors", Permission: PermOwner, Handler: doStats},
+		{Name: "exchanges", Description: "exchange API health", Permission: PermOwner, Handler: doExchanges},
+		{Name: "broadcast", Description: "send a notice to all subscribed chats", Args: []CommandArg{{Name: "text"}}, Permission: PermOwner, Handler: doBroadcast},
+		{Name: "reload", Description: "reload owners, fallback, fx file and rate limits from the config file", Permission: PermOwner, Handler: doReload},
+	} {
+		commands.Register(c)
+	}
+}
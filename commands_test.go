@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		text      string
+		name      string
+		args      []string
+		forUs     bool
+		mentioned bool
+	}{
+		{"/btc", "btc", []string{}, true, false},
+		{"/Convert@AkBot 0.25  BTC ETH", "convert", []string{"0.25", "BTC", "ETH"}, true, true},
+		{"/btc@akbot", "btc", []string{}, true, true},
+		{"/btc@OtherBot", "", nil, false, false},
+		{"hello @AkBot", "", nil, true, false},
+	}
+	for _, c := range cases {
+		name, args, forUs, mentioned := parseCommand(c.text, "AkBot")
+		if name != c.name || forUs != c.forUs || mentioned != c.mentioned || (len(args) > 0 || len(c.args) > 0) && !reflect.DeepEqual(args, c.args) {
+			t.Errorf("parseCommand(%q) = %q %v %v %v", c.text, name, args, forUs, mentioned)
+		}
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	r := NewCommandRegistry()
+	r.Register(&Command{Name: "convert", Aliases: []string{"cv"}, Args: []CommandArg{{Name: "amount"}, {Name: "to", Optional: true}}})
+	if c, ok := r.Lookup("CV"); !ok || c.Name != "convert" {
+		t.Fatal("alias lookup failed")
+	}
+	if c, _ := r.Lookup("convert"); c.Usage() != "/convert <amount> [to]" || c.required() != 1 {
+		t.Fatalf("usage %s", c.Usage())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate alias should panic")
+		}
+	}()
+	r.Register(&Command{Name: "cv"})
+}
+
+func TestRegisteredCommands(t *testing.T) {
+	for _, c := range commands.Commands() {
+		if c.Description == "" || c.Handler == nil {
+			t.Errorf("/%s needs a description and a handler", c.Name)
+		}
+	}
+	if _, ok := commands.Lookup("btc"); !ok {
+		t.Fatal("/btc not registered")
+	}
+	//修改聊天设置或创建群内推送的命令在群里只允许管理员使用
+	for _, name := range []string{"settings", "currency", "lang", "palert", "pdigest", "alertbtc", "quiet", "mute"} {
+		if c, ok := commands.Lookup(name); !ok || c.Permission != PermAdmin {
+			t.Errorf("/%s should require chat admin", name)
+		}
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	for _, c := range commands.BotCommands() {
+		if c.Command == "subs" {
+			t.Fatal("owner commands should not be in the client menu")
+		}
+		if len(c.Description) < 3 || len(c.Description) > 256 {
+			t.Errorf("/%s description length %d", c.Command, len(c.Description))
+		}
+	}
+	if strings.Contains(commands.HelpText(LangEN, false), "/subs") || !strings.Contains(commands.HelpText(LangEN, true), "/subs") {
+		t.Fatal("/subs should only be listed for owners")
+	}
+	if !strings.Contains(commands.HelpText(LangEN, false), "/convert <amount> <from> <to> - ") {
+		t.Fatal("help should list usage")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	if d := levenshtein("kitten", "sitting"); d != 3 {
+		t.Fatalf("levenshtein %d", d)
+	}
+	cases := map[string]string{
+		"btcc":     "btc",
+		"bt":       "btc",
+		"conver":   "convert",
+		"portfoli": "portfolio",
+		"xyzzy":    "",
+		"a":        "",
+	}
+	for name, want := range cases {
+		c, ok := commands.Suggest(name)
+		got := ""
+		if ok {
+			got = c.Name
+		}
+		if got != want {
+			t.Errorf("Suggest(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -7,6 +7,21 @@ import (
 //AppConfig app基础配置
 type AppConfig struct {
 	Botkey string `yaml:"botkey"`
+	//DB 订阅数据库文件
+	DB string `yaml:"db"`
+	//Owners 机器人管理员的Telegram用户ID, 可使用 /subs 等管理命令
+	Owners []int `yaml:"owners"`
+	//FXFile 本地汇率文件, 为空时使用在线汇率
+	FXFile string `yaml:"fx_file"`
+	//Fallback 私聊中无法识别的消息的回复, 为空时按聊天语言回复默认文本, off为不回复
+	Fallback string `yaml:"fallback"`
+	//UserLimit ChatLimit 每个用户和每个群组的命令频率限制
+	UserLimit RateLimitConfig `yaml:"user_limit"`
+	ChatLimit RateLimitConfig `yaml:"chat_limit"`
+	//AdminLimit 机器人管理员和白名单聊天的命令频率限制, 默认不限制
+	AdminLimit RateLimitConfig `yaml:"admin_limit"`
+	//Whitelist 使用AdminLimit的聊天ID
+	Whitelist []int64 `yaml:"whitelist"`
 }
 
 //Config 配置信息表
@@ -19,6 +34,9 @@ type Config struct {
 func NewConfig() *Config {
 	c := new(Config)
 	c.App = new(AppConfig)
+	c.App.DB = "config/bot.db"
+	c.App.UserLimit = RateLimitConfig{PerMinute: 10, Burst: 5}
+	c.App.ChatLimit = RateLimitConfig{PerMinute: 30, Burst: 10}
 
 	return c
 }
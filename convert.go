@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	tb "tg.robot/telebot"
+)
+
+//convertVia 两步兑换时可用的中间币种
+var convertVia = []string{BTC, USDT, USD, USDC}
+
+//ConvertLeg 兑换路径中的一步, 1 From = Rate To
+type ConvertLeg struct {
+	From   string
+	To     string
+	Rate   float64
+	Market *Market
+}
+
+//Route 兑换路径
+type Route struct {
+	Legs []ConvertLeg
+	Rate float64
+}
+
+//baseAsset 交易对的基础币种, 例如 LTCBTC 为 LTC
+func baseAsset(m *Market) string {
+	return strings.TrimSuffix(m.Trader, m.Quote)
+}
+
+//convertLegs 每个行情可以双向兑换
+func convertLegs(markets []*Market) []ConvertLeg {
+	legs := make([]ConvertLeg, 0, len(markets)*2)
+	for _, m := range markets {
+		if m == nil || m.Last <= 0 || m.Quote == "" {
+			continue
+		}
+		base := baseAsset(m)
+		if base == "" || base == m.Quote {
+			continue
+		}
+		legs = append(legs,
+			ConvertLeg{From: base, To: m.Quote, Rate: m.Last, Market: m},
+			ConvertLeg{From: m.Quote, To: base, Rate: 1 / m.Last, Market: m},
+		)
+	}
+	return legs
+}
+
+//bestRoute 直接兑换或经convertVia中转, 得到数量最多的路径, 不计手续费
+func bestRoute(markets []*Market, from string, to string) (*Route, bool) {
+	legs := convertLegs(markets)
+	var best *Route
+	try := func(r *Route) {
+		//相同结果时优先步骤少的路径
+		if best == nil || r.Rate > best.Rate*(1+1e-12) {
+			best = r
+		}
+	}
+	for _, l := range legs {
+		if l.From == from && l.To == to {
+			try(&Route{Legs: []ConvertLeg{l}, Rate: l.Rate})
+		}
+	}
+	for _, via := range convertVia {
+		if via == from || via == to {
+			continue
+		}
+		for _, first := range legs {
+			if first.From != from || first.To != via {
+				continue
+			}
+			for _, second := range legs {
+				if second.From == via && second.To == to {
+					try(&Route{Legs: []ConvertLeg{first, second}, Rate: first.Rate * second.Rate})
+				}
+			}
+		}
+	}
+	return best, best != nil
+}
+
+//convertMarkets 全部交易所和稳定币的行情, 查询失败的交易所忽略
+func convertMarkets() []*Market {
+	exchanges := make([]string, 0, len(exchangeSources))
+	for name := range exchangeSources {
+		exchanges = append(exchanges, name)
+	}
+	sort.Strings(exchanges)
+
+	var sources []func() *Market
+	for _, name := range exchanges {
+		sources = append(sources, exchangeSources[name]...)
+	}
+	for _, asset := range []string{USDT, USDC} {
+		sources = append(sources, stableSources[asset]...)
+	}
+	return fetchMarkets(sources)
+}
+
+//parseConvert 解析 /convert 0.25 BTC ETH
+func parseConvert(args []string) (float64, string, string, error) {
+	if len(args) == 4 && strings.ToLower(args[2]) == "to" {
+		args = append(args[:2:2], args[3])
+	}
+	if len(args) != 3 {
+		return 0, "", "", errors.New("usage: /convert 0.25 BTC ETH")
+	}
+	amount, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || amount <= 0 {
+		return 0, "", "", errors.Errorf("bad amount %s", args[0])
+	}
+	from, to := strings.ToUpper(args[1]), strings.ToUpper(args[2])
+	if from == to {
+		return 0, "", "", errors.New("same asset")
+	}
+	return amount, from, to, nil
+}
+
+//convertText 兑换结果和使用的路径
+func convertText(amount float64, from string, to string, r *Route) string {
+	str := fmt.Sprintf("%g %s = %.8g %s\n", amount, from, amount*r.Rate, to)
+	for _, l := range r.Legs {
+		str = fmt.Sprintf("%s%s→%s %s [%s/%s %.8g]\n", str, l.From, l.To, l.Market.Name, baseAsset(l.Market), l.Market.Quote, l.Market.Last)
+	}
+	return str
+}
+
+func doConvert(chat *tb.Chat, lang string, args []string) {
+	amount, from, to, err := parseConvert(args)
+	if err != nil {
+		bot.SendMessage(chat, T(lang, "convert.usage"), nil)
+		return
+	}
+	r, ok := bestRoute(convertMarkets(), from, to)
+	if !ok {
+		bot.SendMessage(chat, T(lang, "convert.noroute", from, to), nil)
+		return
+	}
+	msg := convertText(amount, from, to, r)
+	log.Info(msg)
+	bot.SendMessage(chat, msg, nil)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func quoted(name string, trader string, quote string, last float64) *Market {
+	m := NewMarket(name, trader, last, 0)
+	m.Quote = quote
+	return m
+}
+
+func TestBestRoute(t *testing.T) {
+	markets := []*Market{
+		quoted(BITSTAMP, BTC, USD, 10000),
+		quoted(BINANCE, BTC, USDT, 10100),
+		quoted(BINANCE, ETHBTC, BTC, 0.02),
+		quoted(BITTREX, ETHBTC, BTC, 0.025),
+		quoted(BINANCE, BCH, USDT, 250),
+		quoted(BITTREX, BCHBTC, BTC, 0.03),
+		nil,
+	}
+
+	r, ok := bestRoute(markets, BTC, ETH)
+	if !ok || len(r.Legs) != 1 || r.Legs[0].Market.Name != BINANCE || math.Abs(r.Rate-50) > 1e-9 {
+		t.Fatalf("btc->eth route %+v", r)
+	}
+
+	r, ok = bestRoute(markets, USDT, BCH)
+	if !ok || len(r.Legs) != 1 || math.Abs(r.Rate-0.004) > 1e-12 {
+		t.Fatalf("usdt->bch should be direct, got %+v", r)
+	}
+
+	//经BTC中转: 1 ETH = 0.025 BTC (Bittrex) = 252.5 USDT (Binance)
+	r, ok = bestRoute(markets, ETH, USDT)
+	if !ok || len(r.Legs) != 2 || r.Legs[0].To != BTC || math.Abs(r.Rate-252.5) > 1e-9 {
+		t.Fatalf("eth->usdt route %+v", r)
+	}
+	text := convertText(2, ETH, USDT, r)
+	if !strings.HasPrefix(text, "2 ETH = 505 USDT") || !strings.Contains(text, "ETH→BTC Bittrex [ETH/BTC 0.025]") {
+		t.Fatalf("unexpected text %q", text)
+	}
+
+	if _, ok = bestRoute(markets, LTC, ETH); ok {
+		t.Fatal("no market for LTC")
+	}
+}
+
+func TestParseConvert(t *testing.T) {
+	amount, from, to, err := parseConvert([]string{"0.25", "btc", "to", "eth"})
+	if err != nil || amount != 0.25 || from != BTC || to != ETH {
+		t.Fatalf("got %v %s %s %v", amount, from, to, err)
+	}
+	if _, _, _, err = parseConvert([]string{"x", "BTC", "ETH"}); err == nil {
+		t.Fatal("bad amount should fail")
+	}
+	if _, _, _, err = parseConvert([]string{"1", "BTC", "btc"}); err == nil {
+		t.Fatal("same asset should fail")
+	}
+}
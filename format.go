@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//messageLimit Telegram单条消息的最大长度
+const messageLimit = 4096
+
+const (
+	preOpen  = "<pre>"
+	preClose = "</pre>"
+)
+
+//htmlOptions 以HTML格式发送
+var htmlOptions = &tb.SendOptions{ParseMode: tb.ModeHTML}
+
+//escapeHTML 转义用户输入和交易所返回的文本, 避免破坏HTML格式
+func escapeHTML(s string) string {
+	return html.EscapeString(s)
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+//plainText 去掉HTML标签, 用于日志和网页输出
+func plainText(s string) string {
+	return html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+}
+
+//bold 粗体标题
+func bold(s string) string {
+	return "<b>" + escapeHTML(s) + "</b>"
+}
+
+//displayWidth 等宽字体下的显示宽度, 中文和emoji占两格
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case r >= 0x1100 && r <= 0x115F,
+			r >= 0x2E80 && r <= 0xA4CF,
+			r >= 0xAC00 && r <= 0xD7A3,
+			r >= 0xF900 && r <= 0xFAFF,
+			r >= 0xFE30 && r <= 0xFE4F,
+			r >= 0xFF00 && r <= 0xFF60,
+			r >= 0xFFE0 && r <= 0xFFE6,
+			r >= 0x2600 && r <= 0x27BF,
+			r >= 0x1F300 && r <= 0x1FAFF:
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+//Table 等宽对齐的表格, 第一列左对齐, 其余列右对齐
+type Table struct {
+	rows [][]string
+}
+
+//Row 添加一行
+func (t *Table) Row(cells ...string) *Table {
+	t.rows = append(t.rows, cells)
+	return t
+}
+
+//String 转义后放在<pre>中, 空表格返回空字符串
+func (t *Table) String() string {
+	if len(t.rows) == 0 {
+		return ""
+	}
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	lines := make([]string, 0, len(t.rows))
+	for _, row := range t.rows {
+		cells := make([]string, 0, len(row))
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-displayWidth(cell))
+			if i == 0 {
+				cells = append(cells, escapeHTML(cell)+padding)
+			} else {
+				cells = append(cells, padding+escapeHTML(cell))
+			}
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " "), " "))
+	}
+	return preOpen + strings.Join(lines, "\n") + preClose
+}
+
+//trendMark 涨跌标记
+func trendMark(percent float64) string {
+	switch {
+	case percent > 0:
+		return "🟢▲"
+	case percent < 0:
+		return "🔴▼"
+	}
+	return "⚪·"
+}
+
+//formatPrice 大于10的价格保留两位小数, 否则保留四位
+func formatPrice(last float64) string {
+	if last > 10 {
+		return fmt.Sprintf("%.2f", last)
+	}
+	return fmt.Sprintf("%.4f", last)
+}
+
+//formatPercent 涨跌幅, percent为小数
+func formatPercent(percent float64) string {
+	return fmt.Sprintf("%+.2f%%", percent*100)
+}
+
+//splitMessage 按行把超长消息拆成多条, 被拆开的<pre>在每条中补齐
+func splitMessage(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	var parts []string
+	var cur strings.Builder
+	curLen := 0
+	inPre := false
+	//预留补齐</pre>的长度
+	reserve := utf8.RuneCountInString(preClose)
+	flush := func() {
+		if curLen == 0 {
+			return
+		}
+		if inPre {
+			cur.WriteString(preClose)
+		}
+		parts = append(parts, cur.String())
+		cur.Reset()
+		curLen = 0
+		if inPre {
+			cur.WriteString(preOpen)
+			curLen = utf8.RuneCountInString(preOpen)
+		}
+	}
+	write := func(s string) {
+		cur.WriteString(s)
+		curLen += utf8.RuneCountInString(s)
+		if strings.LastIndex(s, preOpen) > strings.LastIndex(s, preClose) {
+			inPre = true
+		} else if strings.Contains(s, preClose) {
+			inPre = false
+		}
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			line = "\n" + line
+		}
+		n := utf8.RuneCountInString(line)
+		if curLen+n+reserve > limit {
+			flush()
+			line = strings.TrimPrefix(line, "\n")
+			n = utf8.RuneCountInString(line)
+		}
+		//单行超长时按字符截断
+		for curLen+n+reserve > limit {
+			runes := []rune(line)
+			cut := safeCut(runes, limit-reserve-curLen)
+			//当前这条只有补齐的<pre>时换条也放不下, 只能按字符截断
+			empty := curLen == 0 || inPre && curLen == utf8.RuneCountInString(preOpen)
+			if cut == 0 && !empty {
+				//剩余空间放不下完整的实体或标签, 换到下一条
+				flush()
+				continue
+			}
+			if cut == 0 {
+				cut = limit - reserve - curLen
+			}
+			write(string(runes[:cut]))
+			flush()
+			line = string(runes[cut:])
+			n = len(runes) - cut
+		}
+		write(line)
+	}
+	if curLen > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+//safeCut 把截断位置移到未结束的&...;实体或<...>标签之前
+func safeCut(runes []rune, cut int) int {
+	for _, pair := range [][2]rune{{'&', ';'}, {'<', '>'}} {
+		for i := cut - 1; i >= 0; i-- {
+			if runes[i] == pair[1] {
+				break
+			}
+			if runes[i] == pair[0] {
+				cut = i
+				break
+			}
+		}
+	}
+	return cut
+}
+
+//sendHTML 以HTML格式回复, 超长时拆成多条
+func sendHTML(chat *tb.Chat, text string) {
+	sendHTMLKeyboard(chat, text, nil)
+}
+
+//sendHTMLKeyboard 以HTML格式回复, 按钮附在最后一条
+func sendHTMLKeyboard(chat *tb.Chat, text string, keyboard [][]tb.KeyboardButton) {
+	log.Info(plainText(text))
+	parts := splitMessage(text, messageLimit)
+	for i, part := range parts {
+		options := htmlOptions
+		if i == len(parts)-1 && keyboard != nil {
+			options = &tb.SendOptions{ParseMode: tb.ModeHTML, ReplyMarkup: tb.ReplyMarkup{InlineKeyboard: keyboard}}
+		}
+		if err := bot.SendMessage(chat, part, options); err != nil {
+			log.Error("send message failed.", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	got := (&Table{}).Row("<b>", "1.5", trendMark(0.1)).Row("BTC", "100.25", trendMark(-0.1)).Row("币种", "1", trendMark(0)).String()
+	want := "<pre>&lt;b&gt;     1.5 🟢▲\nBTC  100.25 🔴▼\n币种      1 ⚪·</pre>"
+	if got != want {
+		t.Fatalf("table\n%s\nwant\n%s", got, want)
+	}
+	if (&Table{}).String() != "" {
+		t.Fatal("empty table should render nothing")
+	}
+	if plainText(got) != "<b>     1.5 🟢▲\nBTC  100.25 🔴▼\n币种      1 ⚪·" {
+		t.Fatalf("plain text %q", plainText(got))
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	if parts := splitMessage("short", messageLimit); len(parts) != 1 || parts[0] != "short" {
+		t.Fatalf("short message split into %q", parts)
+	}
+
+	lines := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		lines = append(lines, strings.Repeat("x", 9))
+	}
+	text := "title\n" + preOpen + strings.Join(lines, "\n") + preClose + "\nfooter"
+	parts := splitMessage(text, 100)
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(parts))
+	}
+	var joined []string
+	for _, p := range parts {
+		if n := len([]rune(p)); n > 100 {
+			t.Fatalf("part too long %d: %q", n, p)
+		}
+		if strings.Count(p, preOpen) != strings.Count(p, preClose) {
+			t.Fatalf("unbalanced <pre> in %q", p)
+		}
+		joined = append(joined, plainText(p))
+	}
+	if strings.Join(joined, "\n") != plainText(text) {
+		t.Fatalf("content changed after split: %q", joined)
+	}
+
+	long := strings.Repeat("y", 250)
+	for _, p := range splitMessage(long, 100) {
+		if len(p) > 100 {
+			t.Fatalf("long line part %d", len(p))
+		}
+	}
+
+	//截断位置落在实体或标签中间时移到它之前
+	for _, long := range []string{
+		strings.Repeat("&lt;", 60),
+		strings.Repeat("a<b>x</b>", 30),
+		strings.Repeat("y", 97) + "&amp;" + strings.Repeat("z", 50),
+	} {
+		parts := splitMessage(long, 100)
+		for _, p := range parts {
+			if len([]rune(p)) > 100 {
+				t.Fatalf("long line part %d", len([]rune(p)))
+			}
+			if strings.LastIndex(p, "&") > strings.LastIndex(p, ";") || strings.LastIndex(p, "<") > strings.LastIndex(p, ">") {
+				t.Fatalf("part ends inside an entity or tag: %q", p)
+			}
+		}
+		if strings.Join(parts, "") != long {
+			t.Fatalf("content changed after split: %q", parts)
+		}
+	}
+}
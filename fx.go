@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	yaml "gopkg.in/yaml.v2"
+	tb "tg.robot/telebot"
+)
+
+//fxCacheTTL 在线汇率缓存时间
+const fxCacheTTL = time.Hour
+
+//fxURL 默认在线汇率接口, 以USD为基准, 不需要access key, 每天更新一次
+const fxURL = "https://open.er-api.com/v6/latest/USD"
+
+//depegThreshold 稳定币偏离1 USD超过该比例时标注脱锚
+const depegThreshold = 0.005
+
+//RateSource 法币汇率来源
+type RateSource interface {
+	//Rate 1 USD 可兑换多少 currency
+	Rate(currency string) (float64, error)
+}
+
+//StaticRateSource 固定汇率表, 用于测试和无法访问汇率接口的部署
+type StaticRateSource map[string]float64
+
+//Rate 1 USD 可兑换多少 currency
+func (s StaticRateSource) Rate(currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == USD {
+		return 1, nil
+	}
+	r, ok := s[currency]
+	if !ok || r <= 0 {
+		return 0, errors.Errorf("no rate for %s", currency)
+	}
+	return r, nil
+}
+
+//LoadRateFile 读取yaml汇率文件, 每行 币种: 1 USD 兑换数量, 例如 CNY: 7.1
+func LoadRateFile(path string) (StaticRateSource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[string]float64)
+	if err = yaml.Unmarshal(data, &rates); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", path)
+	}
+	s := make(StaticRateSource, len(rates))
+	for k, v := range rates {
+		s[strings.ToUpper(k)] = v
+	}
+	return s, nil
+}
+
+//HTTPRateSource 在线汇率, 接口返回 {"rates": {"CNY": 7.1}}
+type HTTPRateSource struct {
+	url   string
+	cache *TickerCache
+}
+
+//NewHTTPRateSource create NewHTTPRateSource
+func NewHTTPRateSource(url string) *HTTPRateSource {
+	return &HTTPRateSource{
+		url:   url,
+		cache: NewTickerCache(fxCacheTTL, trackedGet),
+	}
+}
+
+//Rate 1 USD 可兑换多少 currency
+func (s *HTTPRateSource) Rate(currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == USD {
+		return 1, nil
+	}
+	body, err := s.cache.Get(s.url)
+	if err != nil {
+		return 0, err
+	}
+	r := gjson.GetBytes(body, "rates."+currency).Float()
+	if r <= 0 {
+		return 0, errors.Errorf("no rate for %s", currency)
+	}
+	return r, nil
+}
+
+var (
+	fxMu    sync.RWMutex
+	fxRates RateSource = NewHTTPRateSource(fxURL)
+)
+
+//rateSource 当前的汇率来源, /reload 时可能被替换
+func rateSource() RateSource {
+	fxMu.RLock()
+	defer fxMu.RUnlock()
+	return fxRates
+}
+
+//setRateSource 替换汇率来源
+func setRateSource(rates RateSource) {
+	fxMu.Lock()
+	fxRates = rates
+	fxMu.Unlock()
+}
+
+//quoteAsset 交易对的计价币种, bittrex为 USDT-BTC 格式, 其余为 BTCUSDT 格式
+func quoteAsset(market string) string {
+	m := strings.ToUpper(market)
+	if i := strings.Index(m, "-"); i >= 0 {
+		return m[:i]
+	}
+	for _, q := range []string{USDT, USDC, USD, BTC} {
+		if strings.HasSuffix(m, q) {
+			return q
+		}
+	}
+	return ""
+}
+
+//Display 行情的显示币种, nil表示按交易所原始报价显示
+type Display struct {
+	Currency string
+	//Rate 1 USD 可兑换多少 Currency
+	Rate float64
+	//Stable 计价币种对USD的汇率, USD为1
+	Stable map[string]float64
+	//Raw 行情按原始报价显示, 只用Currency计算统一计价后的价差
+	Raw bool
+	//Precision 价格小数位数, 0为自动
+	Precision int
+}
+
+//isStablecoin USDT/USDC
+func isStablecoin(asset string) bool {
+	return asset == USDT || asset == USDC
+}
+
+//NewDisplay 按markets中出现的计价币种查询稳定币汇率, currency可以是法币或USDT/USDC
+func NewDisplay(currency string, rates RateSource, stable func(asset string) (float64, error), markets ...*Market) (*Display, error) {
+	currency = strings.ToUpper(currency)
+	d := &Display{
+		Currency: currency,
+		Stable:   map[string]float64{USD: 1},
+	}
+	assets := make([]string, 0, len(markets)+1)
+	for _, m := range markets {
+		if m != nil {
+			assets = append(assets, m.Quote)
+		}
+	}
+	if isStablecoin(currency) {
+		assets = append(assets, currency)
+	}
+	for _, asset := range assets {
+		if _, ok := d.Stable[asset]; ok || !isStablecoin(asset) {
+			continue
+		}
+		r, err := stable(asset)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s/USD", asset)
+		}
+		d.Stable[asset] = r
+	}
+
+	if isStablecoin(currency) {
+		d.Rate = 1 / d.Stable[currency]
+		return d, nil
+	}
+	rate, err := rates.Rate(currency)
+	if err != nil {
+		return nil, err
+	}
+	d.Rate = rate
+	return d, nil
+}
+
+//Convert 把USD/USDT/USDC报价换算成显示币种, 其它计价(如BTC)返回原价和false
+func (d *Display) Convert(m *Market) (float64, bool) {
+	if d == nil {
+		return m.Last, false
+	}
+	r, ok := d.Stable[m.Quote]
+	if !ok {
+		return m.Last, false
+	}
+	return m.Last * r * d.Rate, true
+}
+
+//Show 行情显示的价格, Raw时为原始报价
+func (d *Display) Show(m *Market) float64 {
+	if d == nil || d.Raw {
+		return m.Last
+	}
+	last, _ := d.Convert(m)
+	return last
+}
+
+//Format 按设置的小数位数显示价格, 未设置时按价格大小选择
+func (d *Display) Format(last float64) string {
+	if d == nil || d.Precision <= 0 {
+		return formatPrice(last)
+	}
+	return strconv.FormatFloat(last, 'f', d.Precision, 64)
+}
+
+//Title 标题后附加行情实际使用的计价, 无法换算的交易对(如BTC计价)保留原计价, 全部无法换算时不附加
+func (d *Display) Title(title string, markets ...*Market) string {
+	if d == nil || d.Raw {
+		return title
+	}
+	var units []string
+	seen := make(map[string]bool)
+	converted := false
+	for _, m := range markets {
+		unit := d.Currency
+		if _, ok := d.Convert(m); ok {
+			converted = true
+		} else {
+			unit = m.Quote
+		}
+		if unit != "" && !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+	if !converted {
+		return title
+	}
+	return fmt.Sprintf("%s (%s)", title, strings.Join(units, "/"))
+}
+
+//Footer 换算使用的汇率, 稳定币按实际USD汇率列出
+func (d *Display) Footer(lang string) string {
+	if d == nil || d.Currency == "" {
+		return ""
+	}
+	str := ""
+	if d.Currency != USD && !isStablecoin(d.Currency) {
+		str = fmt.Sprintf("1 USD = %.4f %s\n", d.Rate, d.Currency)
+	}
+	for _, q := range []string{USDT, USDC} {
+		r, ok := d.Stable[q]
+		if !ok {
+			continue
+		}
+		dev := r - 1
+		tag := ""
+		if math.Abs(dev) >= depegThreshold {
+			tag = T(lang, "fx.depeg")
+		}
+		str = fmt.Sprintf("%s1 %s = %.4f USD [%+.2f%%]%s\n", str, q, r, dev*100, tag)
+	}
+	return str
+}
+
+//stableRate 稳定币对USD的参考价(中位数)
+func stableRate(asset string) (float64, error) {
+	if asset == USD {
+		return 1, nil
+	}
+	sources, ok := stableSources[asset]
+	if !ok {
+		return 0, errors.Errorf("unsupported stablecoin %s", asset)
+	}
+	var prices []float64
+	for _, m := range fetchMarkets(sources) {
+		if m != nil && m.Last > 0 {
+			prices = append(prices, m.Last)
+		}
+	}
+	if len(prices) == 0 {
+		return 0, errors.Errorf("no price for %s", asset)
+	}
+	return median(prices), nil
+}
+
+//displayFor 聊天设置的显示币种和小数位数, 都未设置时返回nil, 只设置小数位数时按原始报价显示
+func displayFor(chatID int64, markets ...*Market) (*Display, error) {
+	s, err := chatSettings.LoadChatSettings(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Currency == "" {
+		if s.Precision == 0 {
+			return nil, nil
+		}
+		return &Display{Raw: true, Precision: s.Precision}, nil
+	}
+	d, err := NewDisplay(s.Currency, rateSource(), stableRate, markets...)
+	if err != nil {
+		return nil, err
+	}
+	d.Precision = s.Precision
+	return d, nil
+}
+
+//compareDisplay 行情对比使用的计价, 未设置显示币种时按原始报价显示, 价差统一按USD计算
+func compareDisplay(chatID int64, markets ...*Market) (*Display, error) {
+	d, err := displayFor(chatID, markets...)
+	if err != nil || (d != nil && !d.Raw) {
+		return d, err
+	}
+	usd, err := NewDisplay(USD, rateSource(), stableRate, markets...)
+	if err != nil {
+		return nil, err
+	}
+	usd.Raw = true
+	if d != nil {
+		usd.Precision = d.Precision
+	}
+	return usd, nil
+}
+
+//doCurrency /currency CNY 设置行情显示币种, /currency off 恢复原始报价
+func doCurrency(chat *tb.Chat, lang string, args []string) {
+	if len(args) == 0 {
+		s, err := chatSettings.LoadChatSettings(chat.ID)
+		if err != nil {
+			log.Error("load chat settings failed.", err)
+			bot.SendMessage(chat, T(lang, "error.query"), nil)
+			return
+		}
+		msg := T(lang, "currency.raw")
+		if s.Currency != "" {
+			msg = T(lang, "currency.current", s.Currency)
+		}
+		bot.SendMessage(chat, msg, nil)
+		return
+	}
+
+	currency := strings.ToUpper(args[0])
+	msg := T(lang, "currency.off")
+	if currency == "OFF" {
+		currency = ""
+	} else {
+		if _, err := rateSource().Rate(currency); err != nil && !isStablecoin(currency) {
+			bot.SendMessage(chat, T(lang, "currency.unsupported", currency), nil)
+			return
+		}
+		msg = T(lang, "currency.set", currency)
+	}
+	//只修改显示币种, 不覆盖同时写入的免打扰摘要
+	if _, err := chatSettings.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.Currency = currency }); err != nil {
+		log.Error("save chat settings failed.", err)
+		bot.SendMessage(chat, T(lang, "error.save"), nil)
+		return
+	}
+	log.Info(msg)
+	bot.SendMessage(chat, msg, nil)
+}
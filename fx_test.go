@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestQuoteAsset(t *testing.T) {
+	cases := map[string]string{
+		"btcusd":     USD,
+		"tBABUSD":    USD,
+		"USDT-BTC":   USDT,
+		"BTC-LTC":    BTC,
+		"BCHABCUSDT": USDT,
+		"usdtusd":    USD,
+		"ltcbtc":     BTC,
+		"CETUSDT":    USDT,
+	}
+	for market, want := range cases {
+		if got := quoteAsset(market); got != want {
+			t.Errorf("quoteAsset(%s) = %s, want %s", market, got, want)
+		}
+	}
+}
+
+func TestLoadRateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fx.yml")
+	if err := ioutil.WriteFile(path, []byte("cny: 7\nEUR: 0.9\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := LoadRateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := rates.Rate("CNY"); r != 7 {
+		t.Fatalf("CNY rate %v", r)
+	}
+	if r, _ := rates.Rate("usd"); r != 1 {
+		t.Fatalf("USD rate %v", r)
+	}
+	if _, err = rates.Rate("JPY"); err == nil {
+		t.Fatal("missing rate should fail")
+	}
+}
+
+func TestSetRateSource(t *testing.T) {
+	old := rateSource()
+	defer setRateSource(old)
+	setRateSource(StaticRateSource{"CNY": 7})
+
+	//reload替换汇率来源时提醒和inline查询可能正在读取
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			rateSource().Rate("CNY")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		setRateSource(StaticRateSource{"CNY": float64(i + 1)})
+	}
+	<-done
+	if r, _ := rateSource().Rate("CNY"); r != 100 {
+		t.Fatalf("CNY rate %v", r)
+	}
+}
+
+func TestDisplayConvert(t *testing.T) {
+	usd := NewMarket(BITSTAMP, BTC, 100, 0)
+	usd.Quote = USD
+	usdt := NewMarket(BINANCE, BTC, 100, 0)
+	usdt.Quote = USDT
+	cross := NewMarket(BINANCE, LTCBTC, 0.01, 0)
+	cross.Quote = BTC
+
+	stable := func(asset string) (float64, error) {
+		if asset == USDT {
+			return 0.98, nil
+		}
+		return 0, errors.Errorf("unexpected %s", asset)
+	}
+	d, err := NewDisplay("cny", StaticRateSource{"CNY": 7}, stable, usd, usdt, cross)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := d.Convert(usd); !ok || v != 700 {
+		t.Fatalf("usd converted to %v %v", v, ok)
+	}
+	if v, _ := d.Convert(usdt); v < 685.99 || v > 686.01 {
+		t.Fatalf("usdt converted to %v", v)
+	}
+	if v, ok := d.Convert(cross); ok || v != 0.01 {
+		t.Fatalf("btc quote should not convert, got %v %v", v, ok)
+	}
+	if title := d.Title("BTC", usd, usdt); title != "BTC (CNY)" {
+		t.Fatalf("title %q", title)
+	}
+	if title := d.Title("BCHBTC", cross); title != "BCHBTC" {
+		t.Fatalf("btc quoted pairs should not be labelled with the display currency, got %q", title)
+	}
+	if title := d.Title("Binance", usdt, cross); title != "Binance (CNY/BTC)" {
+		t.Fatalf("title %q", title)
+	}
+	footer := d.Footer(LangZH)
+	if !strings.Contains(footer, "1 USD = 7.0000 CNY") || !strings.Contains(footer, "1 USDT = 0.9800 USD [-2.00%] 脱锚") {
+		t.Fatalf("footer %q", footer)
+	}
+
+	var raw *Display
+	if v, ok := raw.Convert(usdt); ok || v != 100 {
+		t.Fatal("nil display should keep raw quote")
+	}
+	if _, err = NewDisplay("XXX", StaticRateSource{}, stable); err == nil {
+		t.Fatal("unknown currency should fail")
+	}
+}
+
+func TestFormatCompare(t *testing.T) {
+	a := NewMarket(BITSTAMP, BTC, 100, 0.01)
+	a.Quote = USD
+	b := NewMarket(BINANCE, BTC, 110, 0.02)
+	b.Quote = USD
+	d := &Display{Currency: "EUR", Rate: 0.5, Stable: map[string]float64{USD: 1}}
+	msg := formatCompare(BTC, []*Market{a, b}, d, LangEN)
+	if !strings.HasPrefix(msg, "<b>BTC (EUR)</b>") || !strings.Contains(msg, "max: [55.00] [Binance]") || !strings.Contains(msg, "agiotage(EUR):[5.00][10.00%]") {
+		t.Fatalf("unexpected text %q", msg)
+	}
+}
+
+func TestFormatCompareNormalized(t *testing.T) {
+	a := NewMarket(BITSTAMP, BTC, 100, 0)
+	a.Quote = USD
+	b := NewMarket(BINANCE, BTC, 102, 0)
+	b.Quote = USDT
+	stable := func(asset string) (float64, error) { return 0.99, nil }
+	d, err := NewDisplay(USD, StaticRateSource{}, stable, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Raw = true
+	msg := formatCompare(BTC, []*Market{a, b}, d, LangEN)
+	for _, want := range []string{
+		"Binance  102.00 +0.00%",
+		"max: [102.00] [Binance]",
+		"agiotage:[2.00][2.00%]",
+		"agiotage(USD):[0.98][0.98%]",
+		"1 USDT = 0.9900 USD [-1.00%] depegged",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("%q not in %q", want, msg)
+		}
+	}
+
+	d, err = NewDisplay(USDT, StaticRateSource{}, stable, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Convert(b); math.Abs(v-102) > 1e-9 {
+		t.Fatalf("usdt quote in usdt should not change, got %v", v)
+	}
+
+	x := NewMarket(BITSTAMP, LTCBTC, 0.01, 0)
+	x.Quote = BTC
+	y := NewMarket(BINANCE, LTCBTC, 0.011, 0)
+	y.Quote = BTC
+	if msg = formatCompare(LTCBTC, []*Market{x, y}, d, LangEN); strings.Contains(msg, "agiotage(") {
+		t.Fatalf("btc quotes need no normalization: %q", msg)
+	}
+}
+
+func TestChatSettingsStore(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	s, err := store.LoadChatSettings(-100123)
+	if err != nil || s.ChatID != -100123 || s.Currency != "" {
+		t.Fatalf("default settings %+v %v", s, err)
+	}
+	s.Currency = "CNY"
+	if err = store.SaveChatSettings(s); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = store.LoadChatSettings(-100123); s.Currency != "CNY" {
+		t.Fatalf("loaded %+v", s)
+	}
+}
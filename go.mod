@@ -11,5 +11,7 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/tidwall/gjson v1.1.5
 	github.com/tidwall/match v1.0.1 // indirect
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
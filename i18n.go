@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//支持的语言
+const (
+	LangZH = "zh-CN"
+	LangEN = "en"
+)
+
+//defaultLang 聊天和用户都没有语言设置时使用
+const defaultLang = LangZH
+
+//catalogs 消息目录, 值为fmt格式, 文字中的%写作%%
+var catalogs = map[string]map[string]string{
+	LangZH: {
+		"error.query":      "查询失败，请重试",
+		"error.fx":         "汇率查询失败，请重试",
+		"error.save":       "保存失败，请重试",
+		"error.permission": "没有权限",
+		"error.callback":   "按钮已失效, 请重新发送命令",
+		"error.admin":      "群组中只有管理员可以修改订阅和提醒, 管理员可以在 /settings 中允许所有成员",
+		"ratelimit":        "请求太频繁了, 请 %d 秒后再试",
+		"usage":            "用法: %s",
+		"suggest":          "你是不是要找 /%s ?",
+		"fallback":         "你等着，我等会找着了给你",
+		"hi":               "Hello, %s ! \ndonated bch adress : 32LSbGXhDjUie578wGFPVUhK2M7boNcTsB",
+		"start":            "Hello, %s !\n\n%s",
+
+		"compare.summary":    "最高: [%.2f] [%s]\n最低: [%.2f] [%s]\n价差:[%.2f][%.2f%%]",
+		"compare.normalized": "价差(%s):[%.2f][%.2f%%]",
+		"fx.depeg":           " 脱锚",
+
+		"currency.raw":         "当前按交易所原始报价显示, 设置例如: /currency CNY 或 /currency USDT",
+		"currency.current":     "当前显示币种 %s, 恢复原始报价: /currency off",
+		"currency.off":         "已恢复按交易所原始报价显示",
+		"currency.unsupported": "不支持的币种 %s",
+		"currency.set":         "行情将换算为 %s 显示",
+
+		"convert.usage":   "格式错误, 例如: /convert 0.25 BTC ETH 或 /convert 1000 USDT BCH",
+		"convert.noroute": "没有找到 %s 到 %s 的兑换路径",
+
+		"alert.sub":           "订阅%s提醒成功,间隔1小时",
+		"alert.sub.BTC":       "订阅btc提醒成功,间隔1小时",
+		"alert.sub.BCH":       "订阅bch提醒成功,间隔1小时",
+		"alert.sub.CoinEx":    "订阅coinex提醒成功,间隔1小时",
+		"alert.unsub.BTC":     "取消订阅btc成功,不再提醒",
+		"alert.unsub.BCH":     "取消订阅bch成功,不再提醒",
+		"alert.unsub.CoinEx":  "取消订阅coinex成功,不再提醒",
+		"alert.range78.sub":   "订阅BCH,BTC行情大波动提醒成功，七上八下模式开启 BTC %.2f BCH %.2f",
+		"alert.range78.unsub": "取消订阅BCH,BTC行情大波动提醒成功，七上八下模式关闭",
+		"alert.fall":          "%s价格跌幅 [%.2f]->[%.2f] [%.2f%%]",
+		"alert.rise":          "%s价格涨幅 [%.2f]->[%.2f] [%.2f%%]",
+		"alert.level.above":   "%s价格突破 [%s]: [%s]->[%s]",
+		"alert.level.below":   "%s价格跌破 [%s]: [%s]->[%s]",
+		"alert.move":          "%s价格变动 [%s]->[%s] [%+.2f%%]",
+
+		"wizard.pair":          "选择交易对, /cancel 取消",
+		"wizard.type":          "%s: 选择提醒类型",
+		"wizard.type.level":    "价位提醒",
+		"wizard.type.move":     "涨跌提醒",
+		"wizard.type.report":   "定时推送",
+		"wizard.level":         "%s: 输入提醒价位",
+		"wizard.level.current": "%s, 当前参考价 %s",
+		"wizard.percent":       "%s: 输入涨跌幅百分比, 例如 5",
+		"wizard.interval":      "选择检查或推送间隔, 也可以输入例如 15m",
+		"wizard.invalid":       "输入无效, 请重新输入. %s",
+		"wizard.done.level":    "已设置%s价位提醒: 穿越 %s 时通知, 每%s检查一次",
+		"wizard.done.move":     "已设置%s涨跌提醒: 涨跌超过 %g%% 时通知, 每%s检查一次",
+		"wizard.done.report":   "已订阅%s行情, 每%s推送一次",
+		"wizard.cancel":        "已取消",
+		"wizard.none":          "没有进行中的设置",
+
+		"alerts.title":   "本聊天的提醒, 点击 ✖ 删除",
+		"alerts.none":    "没有提醒, 使用 /newalert 添加",
+		"alerts.level":   "%s 穿越 %s, 每%s检查",
+		"alerts.move":    "%s 涨跌 %g%%, 每%s检查",
+		"alerts.report":  "%s 每%s推送",
+		"alerts.deleted": "已删除",
+
+		"quiet.digest": "免打扰期间的提醒汇总:\n\n%s",
+		"quiet.none":   "未设置免打扰时段",
+		"quiet.hours":  "免打扰时段 %02d:00-%02d:00",
+		"quiet.muted":  "%s\n静音至 %s",
+		"quiet.off":    "免打扰时段已关闭",
+		"quiet.usage":  "格式错误, 例如: /quiet 23-7 或 /quiet off",
+		"quiet.set":    "免打扰时段设置成功 %02d:00-%02d:00, 期间普通提醒将汇总后发送, 大波动提醒照常通知",
+		"mute.usage":   "格式错误, 例如: /mute 30m /mute 2h /mute 1d",
+		"mute.set":     "静音至 %s, 期间普通提醒将汇总后发送",
+		"mute.off":     "已取消静音",
+
+		"hold.usage":        "格式错误, 例如: /hold BTC 0.5 @ 30000 (卖出用负数, 不填价格按当前参考价)",
+		"hold.notEnough":    "持仓不足: %v",
+		"hold.recorded":     "已记录 %s %g @ %.2f",
+		"hold.current":      "%s\n当前持有 %s %g, 平均成本 %.2f",
+		"portfolio.empty":   "没有持仓记录, 使用 /hold BTC 0.5 @ 30000 添加",
+		"portfolio.columns": "币种|数量|价格|成本|市值|盈亏|盈亏%%",
+		"portfolio.summary": "持仓 \n%s\n市值: [%.2f]\n成本: [%.2f]\n盈亏: [%+.2f][%+.2f%%]",
+		"portfolio.below":   "持仓总值跌破 [%.2f]: [%.2f]->[%.2f]",
+		"portfolio.above":   "持仓总值突破 [%.2f]: [%.2f]->[%.2f]",
+		"portfolio.fall":    "持仓总值跌幅 [%.2f]->[%.2f] [%.2f%%]",
+		"portfolio.rise":    "持仓总值涨幅 [%.2f]->[%.2f] [%.2f%%]",
+		"portfolio.digest":  "每日持仓摘要\n%s",
+		"portfolio.change":  "较上次摘要: [%+.2f][%+.2f%%] %s",
+		"palert.usage":      "格式错误, 例如: /palert 50000 /palert 5%% /palert off",
+		"palert.off":        "取消持仓提醒成功,不再提醒",
+		"palert.level":      "订阅持仓总值提醒成功, 穿越 %.2f 时提醒",
+		"palert.move":       "订阅持仓总值提醒成功, 当日涨跌超过 %.2f%% 时提醒",
+		"pdigest.usage":     "格式错误, 例如: /pdigest on 9 或 /pdigest off",
+		"pdigest.off":       "取消每日持仓摘要成功",
+		"pdigest.on":        "订阅每日持仓摘要成功, 每天 %02d:00 发送",
+
+		"stats.title":       "运行统计",
+		"stats.summary":     "运行时间: %s\n聊天: %d\n订阅: %d (已停用 %d)",
+		"stats.commands":    "命令|次数",
+		"stats.providers":   "接口|请求|失败率",
+		"exchanges.title":   "行情接口状态",
+		"exchanges.columns": "接口|状态|请求|失败率|平均耗时|最近成功",
+		"exchanges.error":   "%s 最近错误 (%s前): %s",
+		"exchanges.none":    "启动后还没有请求过行情接口",
+		"broadcast.started": "开始向 %d 个聊天群发",
+		"broadcast.done":    "群发完成: 放入投递队列 %d 条, 失败 %d 条",
+		"reload.done":       "配置已重新加载: 管理员 %d 个, 汇率来源 %s. botkey 和 db 修改需要重启生效",
+		"reload.failed":     "重新加载配置失败: %v",
+
+		"lang.current":     "当前语言: %s, 切换: /lang en 或 /lang zh",
+		"lang.set":         "语言已切换为中文",
+		"lang.unsupported": "不支持的语言 %s, 可选: zh en",
+		"lang.name":        "中文",
+
+		"settings.title":          "聊天设置",
+		"settings.hint":           "点击按钮切换, 其它时区例如: /settings timezone Asia/Tokyo",
+		"settings.lang":           "语言",
+		"settings.timezone":       "时区",
+		"settings.currency":       "显示币种",
+		"settings.precision":      "小数位数",
+		"settings.quiet":          "免打扰",
+		"settings.exchanges":      "交易所",
+		"settings.server":         "服务器时间",
+		"settings.raw":            "原始报价",
+		"settings.auto":           "自动",
+		"settings.off":            "关闭",
+		"settings.all":            "全部",
+		"settings.usage":          "格式错误, 例如: /settings 或 /settings timezone Asia/Tokyo",
+		"settings.badtz":          "未知时区 %s, 请使用IANA时区名称, 例如 Asia/Tokyo",
+		"settings.members":        "修改订阅",
+		"settings.members.admins": "仅管理员",
+		"settings.members.all":    "所有成员",
+
+		"kb.refresh":   "🔄 刷新",
+		"kb.subscribe": "🔔 每小时推送",
+		"kb.chart":     "📈 K线",
+		"kb.back":      "« %s",
+		"kb.updated":   "已更新",
+
+		"cmd.start":         "开始使用并列出命令",
+		"cmd.help":          "列出命令",
+		"cmd.hi":            "打个招呼",
+		"cmd.btc":           "btc行情",
+		"cmd.bch":           "bch行情",
+		"cmd.ltc":           "ltc行情",
+		"cmd.eth":           "eth行情",
+		"cmd.bchbtc":        "bchbtc行情",
+		"cmd.ltcbtc":        "ltcbtc行情",
+		"cmd.ethbtc":        "ethbtc行情",
+		"cmd.coinex":        "coinex全部行情",
+		"cmd.bitstamp":      "bitstamp全部行情",
+		"cmd.poloniex":      "poloniex全部行情",
+		"cmd.bittrex":       "bittrex全部行情",
+		"cmd.bitfinex":      "bitfinex全部行情",
+		"cmd.binance":       "binance全部行情",
+		"cmd.convert":       "币种换算, 例如 /convert 0.25 BTC ETH",
+		"cmd.currency":      "行情显示币种, 例如 /currency CNY",
+		"cmd.lang":          "切换语言, 例如 /lang en",
+		"cmd.settings":      "聊天设置: 语言、时区、显示币种、交易所等",
+		"cmd.alertbtc":      "每小时推送btc行情",
+		"cmd.alertbch":      "每小时推送bch行情",
+		"cmd.alertcoinex":   "每小时推送coinex行情",
+		"cmd.alertrange78":  "BTC,BCH七上八下大波动提醒",
+		"cmd.dalertbtc":     "取消btc推送",
+		"cmd.dalertbch":     "取消bch推送",
+		"cmd.dalertcoinex":  "取消coinex推送",
+		"cmd.dalertrange78": "取消大波动提醒",
+		"cmd.newalert":      "按步骤设置价位、涨跌或定时提醒",
+		"cmd.cancel":        "取消进行中的设置",
+		"cmd.alerts":        "查看和删除本聊天的提醒",
+		"cmd.quiet":         "免打扰时段, 例如 /quiet 23-7",
+		"cmd.mute":          "静音, 例如 /mute 2h",
+		"cmd.unmute":        "取消静音",
+		"cmd.hold":          "记录持仓, 例如 /hold BTC 0.5 @ 30000",
+		"cmd.portfolio":     "持仓市值和盈亏",
+		"cmd.palert":        "持仓提醒, 例如 /palert 50000 或 /palert 5%",
+		"cmd.pdigest":       "每日持仓摘要, 例如 /pdigest on 9",
+		"cmd.subs":          "查看全部订阅",
+		"cmd.stats":         "运行统计: 聊天、订阅、命令次数和交易所失败率",
+		"cmd.exchanges":     "行情接口状态",
+		"cmd.broadcast":     "向全部有订阅的聊天群发通知",
+		"cmd.reload":        "重新加载配置文件中的管理员、默认回复、汇率文件和频率限制",
+	},
+	LangEN: {
+		"error.query":      "Query failed, please try again",
+		"error.fx":         "Exchange rate query failed, please try again",
+		"error.save":       "Save failed, please try again",
+		"error.permission": "Permission denied",
+		"error.callback":   "This button has expired, please send the command again",
+		"error.admin":      "In groups only admins can change alerts and subscriptions, an admin can allow all members in /settings",
+		"ratelimit":        "Too many requests, please try again in %d seconds",
+		"usage":            "Usage: %s",
+		"suggest":          "Did you mean /%s ?",
+		"fallback":         "Sorry, I don't know that one. Try /help",
+		"hi":               "Hello, %s ! \ndonated bch adress : 32LSbGXhDjUie578wGFPVUhK2M7boNcTsB",
+		"start":            "Hello, %s !\n\n%s",
+
+		"compare.summary":    "max: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]",
+		"compare.normalized": "agiotage(%s):[%.2f][%.2f%%]",
+		"fx.depeg":           " depegged",
+
+		"currency.raw":         "Prices are shown as quoted by exchanges, e.g. /currency CNY or /currency USDT",
+		"currency.current":     "Prices are shown in %s, use /currency off for exchange quotes",
+		"currency.off":         "Prices will be shown as quoted by exchanges",
+		"currency.unsupported": "Unsupported currency %s",
+		"currency.set":         "Prices will be shown in %s",
+
+		"convert.usage":   "Bad format, e.g. /convert 0.25 BTC ETH or /convert 1000 USDT BCH",
+		"convert.noroute": "No route from %s to %s",
+
+		"alert.sub":           "Subscribed to %s prices every hour",
+		"alert.sub.BTC":       "Subscribed to BTC prices every hour",
+		"alert.sub.BCH":       "Subscribed to BCH prices every hour",
+		"alert.sub.CoinEx":    "Subscribed to CoinEx prices every hour",
+		"alert.unsub.BTC":     "Unsubscribed from BTC prices",
+		"alert.unsub.BCH":     "Unsubscribed from BCH prices",
+		"alert.unsub.CoinEx":  "Unsubscribed from CoinEx prices",
+		"alert.range78.sub":   "Subscribed to BTC,BCH big move alerts (+7%%/-8%%), BTC %.2f BCH %.2f",
+		"alert.range78.unsub": "Unsubscribed from BTC,BCH big move alerts",
+		"alert.fall":          "%s price down [%.2f]->[%.2f] [%.2f%%]",
+		"alert.rise":          "%s price up [%.2f]->[%.2f] [%.2f%%]",
+		"alert.level.above":   "%s price rose above [%s]: [%s]->[%s]",
+		"alert.level.below":   "%s price fell below [%s]: [%s]->[%s]",
+		"alert.move":          "%s price moved [%s]->[%s] [%+.2f%%]",
+
+		"wizard.pair":          "Choose a pair, /cancel to stop",
+		"wizard.type":          "%s: choose the alert type",
+		"wizard.type.level":    "Price level",
+		"wizard.type.move":     "Price move",
+		"wizard.type.report":   "Scheduled report",
+		"wizard.level":         "%s: enter the price level",
+		"wizard.level.current": "%s, reference price now %s",
+		"wizard.percent":       "%s: enter the move in percent, e.g. 5",
+		"wizard.interval":      "Choose how often to check or report, or type e.g. 15m",
+		"wizard.invalid":       "Invalid input, please try again. %s",
+		"wizard.done.level":    "%s level alert set: notify when price crosses %s, checked every %s",
+		"wizard.done.move":     "%s move alert set: notify on a %g%% move, checked every %s",
+		"wizard.done.report":   "Subscribed to %s prices every %s",
+		"wizard.cancel":        "Cancelled",
+		"wizard.none":          "Nothing to cancel",
+
+		"alerts.title":   "Alerts in this chat, tap ✖ to remove",
+		"alerts.none":    "No alerts, add one with /newalert",
+		"alerts.level":   "%s crosses %s, checked every %s",
+		"alerts.move":    "%s moves %g%%, checked every %s",
+		"alerts.report":  "%s report every %s",
+		"alerts.deleted": "Removed",
+
+		"quiet.digest": "Alerts during quiet hours:\n\n%s",
+		"quiet.none":   "No quiet hours set",
+		"quiet.hours":  "Quiet hours %02d:00-%02d:00",
+		"quiet.muted":  "%s\nMuted until %s",
+		"quiet.off":    "Quiet hours disabled",
+		"quiet.usage":  "Bad format, e.g. /quiet 23-7 or /quiet off",
+		"quiet.set":    "Quiet hours set to %02d:00-%02d:00, regular alerts will be sent as a digest afterwards, big move alerts are still delivered",
+		"mute.usage":   "Bad format, e.g. /mute 30m /mute 2h /mute 1d",
+		"mute.set":     "Muted until %s, regular alerts will be sent as a digest afterwards",
+		"mute.off":     "Unmuted",
+
+		"hold.usage":        "Bad format, e.g. /hold BTC 0.5 @ 30000 (negative amount to sell, price defaults to the reference price)",
+		"hold.notEnough":    "Not enough holdings: %v",
+		"hold.recorded":     "Recorded %s %g @ %.2f",
+		"hold.current":      "%s\nNow holding %s %g, average cost %.2f",
+		"portfolio.empty":   "No holdings yet, add one with /hold BTC 0.5 @ 30000",
+		"portfolio.columns": "Asset|Amount|Price|Cost|Value|P/L|P/L%%",
+		"portfolio.summary": "Portfolio \n%s\nvalue: [%.2f]\ncost: [%.2f]\nP/L: [%+.2f][%+.2f%%]",
+		"portfolio.below":   "Portfolio value fell below [%.2f]: [%.2f]->[%.2f]",
+		"portfolio.above":   "Portfolio value rose above [%.2f]: [%.2f]->[%.2f]",
+		"portfolio.fall":    "Portfolio value down [%.2f]->[%.2f] [%.2f%%]",
+		"portfolio.rise":    "Portfolio value up [%.2f]->[%.2f] [%.2f%%]",
+		"portfolio.digest":  "Daily portfolio digest\n%s",
+		"portfolio.change":  "Since last digest: [%+.2f][%+.2f%%] %s",
+		"palert.usage":      "Bad format, e.g. /palert 50000 /palert 5%% /palert off",
+		"palert.off":        "Portfolio alerts cancelled",
+		"palert.level":      "Portfolio alert set, notify when the value crosses %.2f",
+		"palert.move":       "Portfolio alert set, notify when the value moves more than %.2f%% in a day",
+		"pdigest.usage":     "Bad format, e.g. /pdigest on 9 or /pdigest off",
+		"pdigest.off":       "Daily portfolio digest cancelled",
+		"pdigest.on":        "Daily portfolio digest will be sent at %02d:00",
+
+		"stats.title":       "Statistics",
+		"stats.summary":     "Uptime: %s\nChats: %d\nSubscriptions: %d (%d disabled)",
+		"stats.commands":    "Command|Calls",
+		"stats.providers":   "API|Requests|Errors",
+		"exchanges.title":   "Exchange API health",
+		"exchanges.columns": "API|Status|Requests|Errors|Avg|Last OK",
+		"exchanges.error":   "%s last error (%s ago): %s",
+		"exchanges.none":    "No exchange API requests since start",
+		"broadcast.started": "Broadcasting to %d chats",
+		"broadcast.done":    "Broadcast finished: %d queued, %d failed",
+		"reload.done":       "Config reloaded: %d owners, fx rates from %s. Changes to botkey and db need a restart",
+		"reload.failed":     "Reload config failed: %v",
+
+		"lang.current":     "Current language: %s, switch with /lang en or /lang zh",
+		"lang.set":         "Language set to English",
+		"lang.unsupported": "Unsupported language %s, use zh or en",
+		"lang.name":        "English",
+
+		"settings.title":          "Chat settings",
+		"settings.hint":           "Tap a button to change it, for other time zones use e.g. /settings timezone Asia/Tokyo",
+		"settings.lang":           "Language",
+		"settings.timezone":       "Time zone",
+		"settings.currency":       "Currency",
+		"settings.precision":      "Decimals",
+		"settings.quiet":          "Quiet hours",
+		"settings.exchanges":      "Exchanges",
+		"settings.server":         "server time",
+		"settings.raw":            "exchange quotes",
+		"settings.auto":           "auto",
+		"settings.off":            "off",
+		"settings.all":            "all",
+		"settings.usage":          "Bad format, e.g. /settings or /settings timezone Asia/Tokyo",
+		"settings.badtz":          "Unknown time zone %s, use an IANA name such as Asia/Tokyo",
+		"settings.members":        "Who can change alerts",
+		"settings.members.admins": "admins only",
+		"settings.members.all":    "all members",
+
+		"kb.refresh":   "🔄 Refresh",
+		"kb.subscribe": "🔔 Hourly",
+		"kb.chart":     "📈 Chart",
+		"kb.back":      "« %s",
+		"kb.updated":   "Updated",
+	},
+}
+
+//T 取lang对应的文本并格式化, 缺失时使用默认语言
+func T(lang string, key string, args ...interface{}) string {
+	format, ok := catalogs[lang][key]
+	if !ok {
+		if format, ok = catalogs[defaultLang][key]; !ok {
+			log.Error("missing message %s", key)
+			return key
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+//normalizeLang 把 language_code 或 /lang 参数转换成支持的语言, 不支持时返回空
+func normalizeLang(code string) string {
+	code = strings.ToLower(code)
+	switch {
+	case code == "zh" || strings.HasPrefix(code, "zh-") || strings.HasPrefix(code, "zh_"):
+		return LangZH
+	case code == "en" || strings.HasPrefix(code, "en-") || strings.HasPrefix(code, "en_"):
+		return LangEN
+	}
+	return ""
+}
+
+//chatLang 聊天设置的语言, 未设置时按用户客户端语言
+func chatLang(chatID int64, user *tb.User) string {
+	if chatSettings != nil {
+		s, err := chatSettings.LoadChatSettings(chatID)
+		if err != nil {
+			log.Error("load chat settings failed.", err)
+		} else if s.Lang != "" {
+			return s.Lang
+		}
+	}
+	if user != nil {
+		if lang := normalizeLang(user.Language); lang != "" {
+			return lang
+		}
+	}
+	return defaultLang
+}
+
+//messageLang 回复消息使用的语言
+func messageLang(message tb.Message) string {
+	return chatLang(message.Chat.ID, &message.Sender)
+}
+
+//doLang /lang en 切换聊天语言
+func doLang(message tb.Message, args []string) {
+	lang := messageLang(message)
+	if len(args) == 0 {
+		bot.SendMessage(message.Chat, T(lang, "lang.current", lang), nil)
+		return
+	}
+	next := normalizeLang(args[0])
+	if next == "" {
+		bot.SendMessage(message.Chat, T(lang, "lang.unsupported", args[0]), nil)
+		return
+	}
+	if _, err := chatSettings.UpdateChatSettings(message.Chat.ID, func(s *ChatSettings) { s.Lang = next }); err != nil {
+		log.Error("save chat settings failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.save"), nil)
+		return
+	}
+	bot.SendMessage(message.Chat, T(next, "lang.set"), nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var verbPattern = regexp.MustCompile(`%[-+ #0-9.]*[a-zA-Z%]`)
+
+func verbs(format string) []string {
+	var list []string
+	for _, v := range verbPattern.FindAllString(format, -1) {
+		if v != "%%" {
+			list = append(list, v[len(v)-1:])
+		}
+	}
+	return list
+}
+
+func TestCatalogs(t *testing.T) {
+	zh, en := catalogs[LangZH], catalogs[LangEN]
+	for key, format := range zh {
+		if strings.HasPrefix(key, "cmd.") {
+			if _, ok := commands.Lookup(strings.TrimPrefix(key, "cmd.")); !ok {
+				t.Errorf("%s describes an unknown command", key)
+			}
+			continue
+		}
+		other, ok := en[key]
+		if !ok {
+			t.Errorf("%s missing in %s", key, LangEN)
+			continue
+		}
+		if strings.Join(verbs(format), "") != strings.Join(verbs(other), "") {
+			t.Errorf("%s has different verbs: %q %q", key, format, other)
+		}
+	}
+	for key := range en {
+		if _, ok := zh[key]; !ok {
+			t.Errorf("%s missing in %s", key, LangZH)
+		}
+	}
+	for _, c := range commands.Commands() {
+		if _, ok := zh["cmd."+c.Name]; !ok {
+			t.Errorf("/%s has no %s description", c.Name, LangZH)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T(LangEN, "alert.sub.CoinEx"); got != "Subscribed to CoinEx prices every hour" {
+		t.Fatalf("got %q", got)
+	}
+	if got := T("fr", "palert.usage"); !strings.Contains(got, "/palert 5% ") {
+		t.Fatalf("fallback to %s, got %q", defaultLang, got)
+	}
+	if got := T(LangEN, "no.such.key"); got != "no.such.key" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestNormalizeLang(t *testing.T) {
+	cases := map[string]string{"zh-hans": LangZH, "zh": LangZH, "ZH-CN": LangZH, "en-US": LangEN, "en": LangEN, "de": "", "": ""}
+	for code, want := range cases {
+		if got := normalizeLang(code); got != want {
+			t.Errorf("normalizeLang(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//inlinePageSize 行内查询每页结果数, 超过时通过NextOffset翻页
+const inlinePageSize = 5
+
+//inlineEntries 行内查询可选的币种和交易所, 按显示顺序
+var inlineEntries = []string{BTC, BCH, LTC, ETH, BCHBTC, LTCBTC, ETHBTC, BITSTAMP, POLONIEX, BITTREX, BITFINEX, BINANCE, COINEX}
+
+//matchInline 按前缀匹配币种和交易所, 不区分大小写
+func matchInline(text string) []string {
+	prefix := strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(text), "/"))
+	var matched []string
+	for _, e := range inlineEntries {
+		if strings.HasPrefix(strings.ToUpper(e), prefix) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+//inlinePage 取offset开始的一页, next为下一页的offset, 没有更多时为空
+func inlinePage(entries []string, offset string) (page []string, next string) {
+	start, err := strconv.Atoi(offset)
+	if err != nil || start < 0 || start > len(entries) {
+		start = 0
+	}
+	end := start + inlinePageSize
+	if end >= len(entries) {
+		return entries[start:], ""
+	}
+	return entries[start:end], strconv.Itoa(end)
+}
+
+//inlineArticle 币种对比或交易所行情, 描述为第一行行情
+func inlineArticle(entry string, chatID int64, lang string) *tb.InlineQueryResultArticle {
+	var text string
+	if _, ok := exchangeSources[entry]; ok {
+		text = exchangeText(entry, chatID, lang)
+	} else {
+		text = compareText(entry, chatID, lang)
+	}
+	lines := strings.Split(plainText(text), "\n")
+	desc := lines[0]
+	if len(lines) > 1 {
+		desc = lines[1]
+	}
+	return &tb.InlineQueryResultArticle{
+		InlineQueryResultBase: tb.InlineQueryResultBase{ID: entry},
+		Title:                 entry,
+		Description:           desc,
+		InputMessageContent:   &tb.InputTextMessageContent{Text: splitMessage(text, messageLimit)[0], ParseMode: string(tb.ModeHTML)},
+	}
+}
+
+//answerInline @bot btc 在任意聊天中查询行情, 显示币种按用户私聊的设置换算
+func answerInline(q tb.Query) {
+	lang := chatLang(int64(q.From.ID), &q.From)
+	page, next := inlinePage(matchInline(q.Text), q.Offset)
+
+	results := make(tb.InlineQueryResults, len(page))
+	var wg sync.WaitGroup
+	for i, entry := range page {
+		wg.Add(1)
+		go func(i int, entry string) {
+			defer wg.Done()
+			results[i] = inlineArticle(entry, int64(q.From.ID), lang)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	response := &tb.QueryResponse{
+		Results:    results,
+		CacheTime:  int(tickerCacheTTL / time.Second),
+		IsPersonal: true,
+		NextOffset: next,
+	}
+	if err := bot.AnswerInlineQuery(&q, response); err != nil {
+		log.Error("answer inline query failed.", err)
+	}
+}
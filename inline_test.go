@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchInline(t *testing.T) {
+	cases := map[string][]string{
+		"b":     {BTC, BCH, BCHBTC, BITSTAMP, BITTREX, BITFINEX, BINANCE},
+		"BCH":   {BCH, BCHBTC},
+		" /eth": {ETH, ETHBTC},
+		"coin":  {COINEX},
+		"xrp":   nil,
+	}
+	for text, want := range cases {
+		if got := matchInline(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("matchInline(%q) = %v, want %v", text, got, want)
+		}
+	}
+	if got := matchInline(""); len(got) != len(inlineEntries) {
+		t.Fatalf("empty query should list everything, got %v", got)
+	}
+}
+
+func TestInlinePage(t *testing.T) {
+	entries := matchInline("")
+	var all []string
+	offset := ""
+	for i := 0; ; i++ {
+		page, next := inlinePage(entries, offset)
+		if len(page) > inlinePageSize {
+			t.Fatalf("page %d has %d results", i, len(page))
+		}
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+		offset = next
+	}
+	if !reflect.DeepEqual(all, entries) {
+		t.Fatalf("pages %v, want %v", all, entries)
+	}
+	if page, _ := inlinePage(entries, "bad"); page[0] != BTC {
+		t.Fatalf("bad offset should restart, got %v", page)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+const (
+	//cbRefresh 刷新币种对比, 参数为币种
+	cbRefresh = "r"
+	//cbSubscribe 订阅每小时推送, 参数为币种
+	cbSubscribe = "s"
+	//cbExchange 查看交易所全部行情, 参数为交易所和返回的币种
+	cbExchange = "x"
+)
+
+//chartURL K线图链接
+const chartURL = "https://www.tradingview.com/symbols/%s/"
+
+//keyboardColumns 交易所按钮每行个数
+const keyboardColumns = 3
+
+//chartSymbol K线图使用的交易对, 对USD(T)计价的币种加上USD
+func chartSymbol(symbol string) string {
+	if _, ok := quoteSources[symbol]; ok {
+		return symbol + USD
+	}
+	return symbol
+}
+
+//subscribable 可以订阅每小时推送的币种, 与alertReport支持的币种一致
+func subscribable(symbol string) bool {
+	_, ok := compareSources(symbol)
+	return ok
+}
+
+//priceKeyboard 币种对比的按钮: 刷新、每小时推送、K线图和各交易所行情
+func priceKeyboard(symbol string, lang string) [][]tb.KeyboardButton {
+	row := []tb.KeyboardButton{{Text: T(lang, "kb.refresh"), Data: callbacks.Data(cbRefresh, symbol)}}
+	if subscribable(symbol) {
+		row = append(row, tb.KeyboardButton{Text: T(lang, "kb.subscribe"), Data: callbacks.Data(cbSubscribe, symbol)})
+	}
+	row = append(row, tb.KeyboardButton{Text: T(lang, "kb.chart"), URL: fmt.Sprintf(chartURL, chartSymbol(symbol))})
+
+	keyboard := [][]tb.KeyboardButton{row}
+	var exchanges []tb.KeyboardButton
+	for _, name := range exchangeNames {
+		exchanges = append(exchanges, tb.KeyboardButton{Text: name, Data: callbacks.Data(cbExchange, name, symbol)})
+		if len(exchanges) == keyboardColumns {
+			keyboard = append(keyboard, exchanges)
+			exchanges = nil
+		}
+	}
+	if len(exchanges) > 0 {
+		keyboard = append(keyboard, exchanges)
+	}
+	return keyboard
+}
+
+//exchangeKeyboard 交易所行情的按钮: 返回币种对比和刷新
+func exchangeKeyboard(exchange string, symbol string, lang string) [][]tb.KeyboardButton {
+	return [][]tb.KeyboardButton{{
+		{Text: T(lang, "kb.back", symbol), Data: callbacks.Data(cbRefresh, symbol)},
+		{Text: T(lang, "kb.refresh"), Data: callbacks.Data(cbExchange, exchange, symbol)},
+	}}
+}
+
+//editHTML 修改按钮所在的消息, 内容没有变化时不算失败
+func editHTML(chat *tb.Chat, messageID int, text string, keyboard [][]tb.KeyboardButton) error {
+	options := &tb.SendOptions{ParseMode: tb.ModeHTML, ReplyMarkup: tb.ReplyMarkup{InlineKeyboard: keyboard}}
+	err := bot.EditMessageText(chat, messageID, splitMessage(text, messageLimit)[0], options)
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+//priceMessage 按钮所在的消息, 行内模式发送的消息没有chat, 不支持更新
+func priceMessage(cb tb.Callback, response *tb.CallbackResponse) (*tb.Chat, string, bool) {
+	lang := chatLang(cb.Message.Chat.ID, &cb.Sender)
+	if cb.Message.ID == 0 {
+		response.Text = T(lang, "error.callback")
+		return nil, lang, false
+	}
+	return &cb.Message.Chat, lang, true
+}
+
+//editAnswer 更新消息后的提示
+func editAnswer(err error, lang string, response *tb.CallbackResponse) {
+	if err != nil {
+		log.Error("edit message failed.", err)
+		response.Text = T(lang, "error.query")
+		return
+	}
+	response.Text = T(lang, "kb.updated")
+}
+
+//onRefresh 刷新币种对比
+func onRefresh(cb tb.Callback, args []string, response *tb.CallbackResponse) {
+	chat, lang, ok := priceMessage(cb, response)
+	if !ok {
+		return
+	}
+	if _, ok = compareSources(args[0]); !ok {
+		response.Text = T(lang, "error.callback")
+		return
+	}
+	editAnswer(editHTML(chat, cb.Message.ID, compareText(args[0], chat.ID, lang), priceKeyboard(args[0], lang)), lang, response)
+}
+
+//onExchange 在原消息上显示交易所全部行情
+func onExchange(cb tb.Callback, args []string, response *tb.CallbackResponse) {
+	chat, lang, ok := priceMessage(cb, response)
+	if !ok {
+		return
+	}
+	if _, ok = exchangeSources[args[0]]; !ok {
+		response.Text = T(lang, "error.callback")
+		return
+	}
+	editAnswer(editHTML(chat, cb.Message.ID, exchangeText(args[0], chat.ID, lang), exchangeKeyboard(args[0], args[1], lang)), lang, response)
+}
+
+//onSubscribe 订阅每小时推送
+func onSubscribe(cb tb.Callback, args []string, response *tb.CallbackResponse) {
+	chat, lang, ok := priceMessage(cb, response)
+	if !ok || !callbackAllowed(cb, lang, response) {
+		return
+	}
+	if !subscribable(args[0]) {
+		response.Text = T(lang, "error.callback")
+		return
+	}
+	subscribeReport(chat.ID, args[0])
+	response.Text = T(lang, "alert.sub", args[0])
+}
+
+func init() {
+	callbacks.Handle(cbRefresh, 1, onRefresh)
+	callbacks.Handle(cbExchange, 2, onExchange)
+	callbacks.Handle(cbSubscribe, 1, onSubscribe)
+}
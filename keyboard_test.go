@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPriceKeyboard(t *testing.T) {
+	keyboard := priceKeyboard(BTC, LangEN)
+	if len(keyboard) != 3 || len(keyboard[0]) != 3 {
+		t.Fatalf("keyboard layout %v", keyboard)
+	}
+	if keyboard[0][2].URL != "https://www.tradingview.com/symbols/BTCUSD/" || keyboard[0][2].Data != "" {
+		t.Fatalf("chart button %+v", keyboard[0][2])
+	}
+	action, args, err := callbacks.Decode(keyboard[1][0].Data)
+	if err != nil || action != cbExchange || len(args) != 2 || args[0] != BITSTAMP || args[1] != BTC {
+		t.Fatalf("exchange button %q", keyboard[1][0].Data)
+	}
+	for _, row := range keyboard {
+		for _, b := range row {
+			if len(b.Data) > 64 {
+				t.Fatalf("callback data too long %q", b.Data)
+			}
+		}
+	}
+
+	//支持行情对比的交易对都可以订阅, 与消息目录无关
+	if row := priceKeyboard(LTCBTC, LangEN)[0]; len(row) != 3 || row[1].Text != T(LangEN, "kb.subscribe") || row[2].URL != "https://www.tradingview.com/symbols/LTCBTC/" {
+		t.Fatalf("ltcbtc keyboard %+v", row)
+	}
+	if row := priceKeyboard("DOGE", LangEN)[0]; len(row) != 2 {
+		t.Fatalf("unsupported symbol should have no subscribe button, got %+v", row)
+	}
+}
@@ -1,13 +1,10 @@
 package main
 
 import (
-	"encoding/gob"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -27,6 +24,10 @@ const (
 	LTCBTC = "LTCBTC"
 	ETHBTC = "ETHBTC"
 
+	USD  = "USD"
+	USDT = "USDT"
+	USDC = "USDC"
+
 	BITSTAMP = "Bitstamp"
 	POLONIEX = "Poloniex"
 	BITTREX  = "Bittrex"
@@ -41,6 +42,8 @@ type Market struct {
 	Trader        string
 	Last          float64
 	PercentChange float64
+	//Quote 计价币种 USD/USDT/USDC/BTC
+	Quote string
 }
 
 //Account account info
@@ -56,13 +59,29 @@ type Account struct {
 
 //Subscription 订阅通知
 type Subscription struct {
-	Chat     *tb.Chat
-	Trader   string
-	Type     int
-	BCHPrice float64
-	BTCPrice float64
-	Duration int
-	LastTime int
+	ChatID   int64   `yaml:"chat_id" json:"chat_id"`
+	Trader   string  `yaml:"trader" json:"trader"`
+	Type     int     `yaml:"type" json:"type"`
+	BCHPrice float64 `yaml:"bch_price" json:"bch_price"`
+	BTCPrice float64 `yaml:"btc_price" json:"btc_price"`
+	Duration int     `yaml:"duration" json:"duration"`
+	LastTime int     `yaml:"last_time" json:"last_time"`
+	//Disabled 聊天不可达(屏蔽机器人/聊天不存在)后停用, 重新订阅时恢复
+	Disabled bool `yaml:"disabled" json:"disabled"`
+	//UserID 持仓提醒对应的Telegram用户
+	UserID int `yaml:"user_id,omitempty" json:"user_id,omitempty"`
+	//Level 持仓总值提醒价位
+	Level float64 `yaml:"level,omitempty" json:"level,omitempty"`
+	//Percent 持仓总值当日涨跌提醒百分比
+	Percent float64 `yaml:"percent,omitempty" json:"percent,omitempty"`
+	//Baseline 上次检查时的持仓总值, 当日涨跌提醒为当日开始时的总值
+	Baseline float64 `yaml:"baseline,omitempty" json:"baseline,omitempty"`
+	//DayStart Baseline所属日期的0点(秒)
+	DayStart int `yaml:"day_start,omitempty" json:"day_start,omitempty"`
+	//AlertedDay 当日涨跌提醒已发送时为DayStart, 每天最多提醒一次
+	AlertedDay int `yaml:"alerted_day,omitempty" json:"alerted_day,omitempty"`
+	//Hour 每日摘要在聊天时区的发送时间
+	Hour int `yaml:"hour,omitempty" json:"hour,omitempty"`
 }
 
 //LocalMilliscond LocalMilliscond
@@ -78,7 +97,6 @@ func LocalSecond() int {
 //NewSubscription create NewSubscription
 func NewSubscription(trader string, t int, duration int) *Subscription {
 	return &Subscription{
-		Chat:     nil,
 		Trader:   trader,
 		Type:     t,
 		Duration: duration,
@@ -88,6 +106,11 @@ func NewSubscription(trader string, t int, duration int) *Subscription {
 	}
 }
 
+//Recipient 订阅对应的聊天
+func (s *Subscription) Recipient() *tb.Chat {
+	return &tb.Chat{ID: s.ChatID}
+}
+
 //NewMarket create new Market  data
 func NewMarket(name string, trader string, last float64, percentChange float64) *Market {
 
@@ -112,32 +135,22 @@ func NewAccount(name string) *Account {
 	}
 }
 
-//Output output string
-func Output(rest ...*Market) string {
-	str := ""
+//Output 各交易所行情表格
+func Output(d *Display, rest ...*Market) string {
+	t := &Table{}
 	for _, v := range rest {
-		if v.Last > 10 {
-			str = fmt.Sprintf("%s%s [%.2f] %.2f%%\n", str, v.Name, v.Last, v.PercentChange*100)
-		} else {
-			str = fmt.Sprintf("%s%s [%.4f] %.2f%%\n", str, v.Name, v.Last, v.PercentChange*100)
-		}
-
+		t.Row(v.Name, d.Format(d.Show(v)), formatPercent(v.PercentChange), trendMark(v.PercentChange))
 	}
-	return str
+	return t.String()
 }
 
-//Output2 output string
-func Output2(rest ...*Market) string {
-	str := ""
+//Output2 单个交易所各交易对行情表格
+func Output2(d *Display, rest ...*Market) string {
+	t := &Table{}
 	for _, v := range rest {
-		if v.Last > 10 {
-			str = fmt.Sprintf("%s%s [%.2f] %.2f%%\n", str, v.Trader, v.Last, v.PercentChange*100)
-		} else {
-			str = fmt.Sprintf("%s%s [%.4f] %.2f%%\n", str, v.Trader, v.Last, v.PercentChange*100)
-		}
-
+		t.Row(v.Trader, d.Format(d.Show(v)), formatPercent(v.PercentChange), trendMark(v.PercentChange))
 	}
-	return str
+	return t.String()
 }
 
 //Minimum Minimum
@@ -176,14 +189,7 @@ func HasNull(rest ...*Market) bool {
 }
 func coinex(market string, trader string) *Market {
 	url := "https://api.coinex.com/v1/market/ticker?market=" + market
-	resp, err := http.Get(url)
-	if err != nil {
-		// handle error
-		return nil
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := tickers.Get(url)
 	if err != nil {
 		// handle error
 		return nil
@@ -194,18 +200,13 @@ func coinex(market string, trader string) *Market {
 
 	percentChange := (last - open) / open
 
-	return NewMarket(COINEX, trader, last, percentChange)
+	m := NewMarket(COINEX, trader, last, percentChange)
+	m.Quote = quoteAsset(market)
+	return m
 }
 func bitfinex(market string, trader string) *Market {
 	url := "https://api.bitfinex.com/v2/ticker/" + market
-	resp, err := http.Get(url)
-	if err != nil {
-		// handle error
-		return nil
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := tickers.Get(url)
 	if err != nil {
 		// handle error
 		return nil
@@ -221,19 +222,14 @@ func bitfinex(market string, trader string) *Market {
 		last = arr[6].Float()
 		percent = arr[5].Float()
 	}
-	return NewMarket(BITFINEX, trader, last, percent)
+	m := NewMarket(BITFINEX, trader, last, percent)
+	m.Quote = quoteAsset(market)
+	return m
 
 }
 func poloniex() *Account {
 	url := "https://poloniex.com/public?command=returnTicker"
-	resp, err := http.Get(url)
-	if err != nil {
-		// handle error
-		return nil
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := tickers.Get(url)
 	if err != nil {
 		// handle error
 		return nil
@@ -255,24 +251,28 @@ func poloniex() *Account {
 	account.LTCBTC.PercentChange = gjson.GetBytes(body, "BTC_LTC.percentChange").Float()
 	account.ETHBTC.PercentChange = gjson.GetBytes(body, "BTC_ETH.percentChange").Float()
 
+	account.BTC.Quote = USDT
+	account.BCH.Quote = USDC
+	account.LTC.Quote = USDT
+	account.ETH.Quote = USDT
+	account.BCHBTC.Quote = BTC
+	account.LTCBTC.Quote = BTC
+	account.ETHBTC.Quote = BTC
+
 	return account
 }
 
 func bittrex(market string, trader string) *Market {
-	resp, err := http.Get("https://bittrex.com/api/v1.1/public/getmarketsummary?market=" + market)
+	body, err := tickers.Get("https://bittrex.com/api/v1.1/public/getmarketsummary?market=" + market)
 	if err != nil {
 		// handle error
 		return nil
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		// handle error
+	result := gjson.GetBytes(body, "result").Array()
+	if len(result) == 0 {
 		return nil
 	}
-
-	result := gjson.GetBytes(body, "result").Array()
 	row := result[0].Map()
 
 	last := row["Last"].Float()
@@ -280,19 +280,14 @@ func bittrex(market string, trader string) *Market {
 
 	percentChange := (last - prev) / prev
 
-	return NewMarket(BITTREX, trader, last, percentChange)
+	m := NewMarket(BITTREX, trader, last, percentChange)
+	m.Quote = quoteAsset(market)
+	return m
 
 }
 
 func bitstamp(market string, trader string) *Market {
-	resp, err := http.Get("https://www.bitstamp.net/api/v2/ticker/" + market + "/")
-	if err != nil {
-		// handle error
-		return nil
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := tickers.Get("https://www.bitstamp.net/api/v2/ticker/" + market + "/")
 	if err != nil {
 		// handle error
 		return nil
@@ -303,19 +298,15 @@ func bitstamp(market string, trader string) *Market {
 
 	percentChange := (last - open) / open
 
-	return NewMarket(BITSTAMP, trader, last, percentChange)
+	m := NewMarket(BITSTAMP, trader, last, percentChange)
+	m.Quote = quoteAsset(market)
+	return m
 
 }
 
 //Binance 币安价格查询
 func Binance(market string, trader string) *Market {
-	resp, err := http.Get("https://api.binance.com/api/v1/ticker/24hr?symbol=" + market)
-	if err != nil {
-		// handle error
-		return nil
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := tickers.Get("https://api.binance.com/api/v1/ticker/24hr?symbol=" + market)
 	if err != nil {
 		// handle error
 		return nil
@@ -326,219 +317,198 @@ func Binance(market string, trader string) *Market {
 
 	percentChange := (last - open) / open
 
-	return NewMarket(BINANCE, trader, last, percentChange)
+	m := NewMarket(BINANCE, trader, last, percentChange)
+	m.Quote = quoteAsset(market)
+	return m
 }
 
-var tgSubscription map[string]*Subscription
-var subscriptionFile string
+var subscriptions *SubscriptionManager
 var bot *tb.Bot
 
-func saveSubscription() {
-
-	file, _ := os.OpenFile(subscriptionFile, os.O_RDWR|os.O_CREATE, 0777)
-	defer file.Close()
-	enc := gob.NewEncoder(file)
-	if err := enc.Encode(tgSubscription); err != nil {
-		fmt.Println(err)
+func addSubscription(key string, sub *Subscription) {
+	if err := subscriptions.Add(key, sub); err != nil {
+		log.Error("save subscription failed.", err)
+		return
+	}
+	scheduleSubscription(key, *sub)
+}
+func updateSubscription(key string, fn func(sub *Subscription)) {
+	if err := subscriptions.Update(key, fn); err != nil && err != ErrSubscriptionNotFound {
+		log.Error("save subscription failed.", err)
 	}
-
 }
-func loadSubscription(name string) {
+func deleteSubscription(key string) {
+	if _, err := subscriptions.Remove(key); err != nil {
+		log.Error("delete subscription failed.", err)
+	}
+	scheduler.Cancel(key)
+}
 
-	file, _ := os.OpenFile(subscriptionFile, os.O_RDWR|os.O_CREATE, 0777)
-	defer file.Close()
+//formatCompare 同一币种各交易所的行情, 以及最高价、最低价和价差, 返回HTML
+//最高价、最低价按统一计价比较, agiotage为原始报价的价差, agiotage(d.Currency)为统一计价后的价差
+func formatCompare(title string, markets []*Market, d *Display, lang string) string {
+	rawMin := Minimum(markets[0], markets[1:]...)
+	rawMax := Maximum(markets[0], markets[1:]...)
+	rawAgiotage := rawMax.Last - rawMin.Last
+	rawPer := rawAgiotage / rawMin.Last * 100
+
+	normalized := make([]*Market, 0, len(markets))
+	converted := false
+	for _, m := range markets {
+		last, ok := d.Convert(m)
+		converted = converted || ok
+		n := *m
+		n.Last = last
+		normalized = append(normalized, &n)
+	}
+	min := Minimum(normalized[0], normalized[1:]...)
+	max := Maximum(normalized[0], normalized[1:]...)
+	agiotage := max.Last - min.Last
+	per := agiotage / min.Last * 100
+
+	maxLast, minLast := max.Last, min.Last
+	if d == nil || d.Raw {
+		maxLast = markets[indexOf(normalized, max)].Last
+		minLast = markets[indexOf(normalized, min)].Last
+	}
+	out := Output(d, markets...)
+	msg := fmt.Sprintf("%s\n%s\n%s", bold(d.Title(title, markets...)), out, escapeHTML(T(lang, "compare.summary", maxLast, max.Name, minLast, min.Name, rawAgiotage, rawPer)))
+	if converted {
+		msg = fmt.Sprintf("%s\n%s", msg, escapeHTML(T(lang, "compare.normalized", d.Currency, agiotage, per)))
+	}
+	if footer := d.Footer(lang); footer != "" {
+		msg = fmt.Sprintf("%s\n\n%s", msg, escapeHTML(footer))
+	}
+	return msg
+}
 
-	dec := gob.NewDecoder(file)
-	err2 := dec.Decode(&tgSubscription)
-	if err2 != nil {
-		fmt.Println(err2)
-		fmt.Println(tgSubscription)
-		if tgSubscription == nil {
-			tgSubscription = make(map[string]*Subscription)
-			saveSubscription()
+func indexOf(markets []*Market, m *Market) int {
+	for i, v := range markets {
+		if v == m {
+			return i
 		}
-		return
 	}
+	return -1
+}
 
+//formatExchange 单个交易所的全部行情, 返回HTML
+func formatExchange(title string, markets []*Market, d *Display, lang string) string {
+	return fmt.Sprintf("%s\n%s\n%s", bold(d.Title(title, markets...)), Output2(d, markets...), escapeHTML(d.Footer(lang)))
 }
-func alert() {
-	for {
 
-		currentTime := LocalSecond()
-		for k, sub := range tgSubscription {
-			if currentTime-sub.LastTime > sub.Duration {
-				chat := new(tb.Chat)
-				if sub.Chat != nil {
-					str, _ := json.Marshal(sub.Chat)
-					_ = json.Unmarshal(str, chat)
-					if sub.Type == 2 {
-						btcm := bitstamp("btcusd", BTC)
-						bchm := bitstamp("bchusd", BCH)
-						if HasNull(bchm, btcm) {
-							continue
-						}
-						if sub.BTCPrice > 0 && sub.BCHPrice > 0 {
-
-							btcPercentChange := (btcm.Last - sub.BTCPrice) / btcm.Last
-							bchPercentChange := (bchm.Last - sub.BCHPrice) / bchm.Last
-
-							if btcPercentChange >= 0.07 || btcPercentChange <= -0.08 {
-
-								msg := fmt.Sprintf("BTC价格跌幅 [%.2f]->[%.2f] [%.2f%%]", sub.BTCPrice, btcm.Last, btcPercentChange*100)
-								if btcPercentChange > 0 {
-									msg = fmt.Sprintf("BTC价格涨幅 [%.2f]->[%.2f] [%.2f%%]", sub.BTCPrice, btcm.Last, btcPercentChange*100)
-
-								}
-								bot.SendMessage(chat, msg, nil)
-								sub.BTCPrice = btcm.Last
-								saveSubscription()
-
-								doBTC(chat)
-							}
-							if bchPercentChange >= 0.07 || bchPercentChange <= -0.08 {
-
-								msg := fmt.Sprintf("BCH价格跌幅 [%.2f]->[%.2f] [%.2f%%]", sub.BCHPrice, bchm.Last, bchPercentChange*100)
-								if bchPercentChange > 0 {
-									msg = fmt.Sprintf("BCH价格涨幅 [%.2f]->[%.2f] [%.2f%%]", sub.BCHPrice, bchm.Last, bchPercentChange*100)
-
-								}
-								log.Info(msg)
-								bot.SendMessage(chat, msg, nil)
-								sub.BCHPrice = bchm.Last
-								saveSubscription()
-								doBCH(chat)
-							}
-						} else {
-							sub.BCHPrice = bchm.Last
-							sub.BTCPrice = btcm.Last
-							saveSubscription()
-							msg := fmt.Sprintf("订阅BCH,BTC行情大波动提醒成功，七上八下模式开启 BTC %.2f BCH %.2f", sub.BTCPrice, sub.BCHPrice)
-							log.Info(msg)
-							bot.SendMessage(chat, msg, nil)
-						}
-					} else {
-						if sub.Trader == BTC {
-							//fmt.Printf("alert btc price to  %d", sub.Chat.ID)
-							doBTC(chat)
-						} else if sub.Trader == BCH {
-							//fmt.Printf("alert BCH price to  %d", sub.Chat.ID)
-							doBCH(chat)
-
-						} else if sub.Trader == COINEX {
-							doCoinex(chat)
-						}
-					}
-				}
-
-				if tgSubscription[k] != nil {
-					tgSubscription[k].LastTime = currentTime
-				}
-			}
-		}
-		select {
+//compareText 币种行情对比, 按chatID的设置换算显示币种
+func compareText(symbol string, chatID int64, lang string) string {
+	msg, _ := compareReport(symbol, chatID, lang)
+	return msg
+}
 
-		case <-time.After(time.Millisecond * 1000):
-			//	fmt.Printf("tick\n")
-			continue
-		}
+//compareReport 同compareText, 查询行情或汇率失败时ok为false, msg为错误提示
+func compareReport(symbol string, chatID int64, lang string) (msg string, ok bool) {
+	sources, ok := compareSources(symbol)
+	if !ok {
+		return T(lang, "error.query"), false
 	}
-}
-func doCoinex(chat *tb.Chat) {
-	last1 := coinex("BTCUSDT", BTC)
-	last2 := coinex("BCHUSDT", BCH)
-	last3 := coinex("LTCUSDT", LTC)
-	last4 := coinex("ETHUSDT", ETH)
-	last5 := coinex("BCHBTC", BCHBTC)
-	last6 := coinex("LTCBTC", LTCBTC)
-	last7 := coinex("ETHBTC", ETHBTC)
-	last8 := coinex("CETUSDT", "CETUSDT")
-	if HasNull(last1, last2, last3, last4, last5, last6, last7, last8) {
-		bot.SendMessage(chat, "查询失败，请重试", nil)
-	} else {
-		out := Output2(last1, last2, last3, last4, last5, last6, last7, last8)
-		msg := fmt.Sprintf("Coinex: \n%s\n", out)
-		bot.SendMessage(chat, msg, nil)
-		log.Info(msg)
-	}
-}
-func doBTC(chat *tb.Chat) {
-	last1 := bitstamp("btcusd", BTC)
-	account := poloniex()
-	last2 := account.BTC
-	last3 := bittrex("USDT-BTC", BTC)
-	last4 := bitfinex("tBTCUSD", BTC)
-	last5 := Binance("BTCUSDT", BTC)
-	last6 := coinex("BTCUSDT", BTC)
-	if HasNull(last1, last2, last3, last4, last5, last6) {
-		msg := "查询失败，请重试"
-		log.Info(msg)
-		bot.SendMessage(chat, msg, nil)
+	markets := fetchMarkets(sources)
+	if HasNull(markets...) {
+		return T(lang, "error.query"), false
+	}
+	if s, err := chatSettings.LoadChatSettings(chatID); err != nil {
+		log.Error("load chat settings failed.", err)
 	} else {
-		min := Minimum(last1, last2, last3, last4, last5, last6)
-		max := Maximum(last1, last2, last3, last4, last5, last6)
-		agiotage := max.Last - min.Last
-		per := agiotage / min.Last * 100
-		out := Output(last1, last2, last3, last4, last5, last6)
-		msg := fmt.Sprintf("BTC \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-		log.Info(msg)
-		bot.SendMessage(chat, msg, nil)
-	}
-}
-
-func doBCH(chat *tb.Chat) {
-	account := poloniex()
-	last1 := account.BCH
-	last2 := bittrex("USDT-BCH", BCH)
-	last3 := bitfinex("tBABUSD", BCH)
-	last4 := bitstamp("bchusd", BCH)
-	last5 := Binance("BCHABCUSDT", BCH)
-	last6 := coinex("BCHUSDT", BCH)
-	if HasNull(last1, last2, last3, last4, last5, last6) {
-		msg := "查询失败，请重试"
-		log.Info(msg)
-		bot.SendMessage(chat, msg, nil)
+		markets = s.FilterMarkets(markets)
+	}
+	d, err := compareDisplay(chatID, markets...)
+	if err != nil {
+		log.Error("query fx rate failed.", err)
+		return T(lang, "error.fx"), false
+	}
+	return formatCompare(symbol, markets, d, lang), true
+}
+
+//exchangeText 交易所行情, 按chatID的设置换算显示币种
+func exchangeText(exchange string, chatID int64, lang string) string {
+	msg, _ := exchangeReport(exchange, chatID, lang)
+	return msg
+}
+
+//exchangeReport 同exchangeText, 查询行情或汇率失败时ok为false, msg为错误提示
+func exchangeReport(exchange string, chatID int64, lang string) (msg string, ok bool) {
+	sources, ok := exchangeSources[exchange]
+	if !ok {
+		return T(lang, "error.query"), false
+	}
+	markets := fetchMarkets(sources)
+	if HasNull(markets...) {
+		return T(lang, "error.query"), false
+	}
+	d, err := displayFor(chatID, markets...)
+	if err != nil {
+		log.Error("query fx rate failed.", err)
+		return T(lang, "error.fx"), false
+	}
+	return formatExchange(exchange, markets, d, lang), true
+}
+
+//doCoinexCommand /coinex 全部行情, /coinex CETUSDT 单个交易对
+func doCoinexCommand(message tb.Message, args []string) {
+	lang := messageLang(message)
+	if len(args) == 0 {
+		sendHTML(&message.Chat, exchangeText(COINEX, message.Chat.ID, lang))
+		return
+	}
+	last1 := coinex(args[0], args[0])
+	if HasNull(last1) {
+		bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+	} else if d, err := displayFor(message.Chat.ID, last1); err != nil {
+		log.Error("query fx rate failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.fx"), nil)
 	} else {
-		min := Minimum(last1, last2, last3, last4, last5, last6)
-		max := Maximum(last1, last2, last3, last4, last5, last6)
-		agiotage := max.Last - min.Last
-		per := agiotage / min.Last * 100
-		out := Output(last1, last2, last3, last4, last5, last6)
-		msg := fmt.Sprintf("BCH \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-		log.Info(msg)
-		bot.SendMessage(chat, msg, nil)
+		sendHTML(&message.Chat, formatExchange(COINEX, []*Market{last1}, d, lang))
 	}
 }
+
 func web() {
 	r := gin.Default()
 	r.GET("/coinex", func(c *gin.Context) {
-		text := ""
-		last1 := coinex("BTCUSDT", BTC)
-		last2 := coinex("BCHUSDT", BCH)
-		last3 := coinex("LTCUSDT", LTC)
-		last4 := coinex("ETHUSDT", ETH)
-		last5 := coinex("BCHBTC", BCHBTC)
-		last6 := coinex("LTCBTC", LTCBTC)
-		last7 := coinex("ETHBTC", ETHBTC)
-		last8 := coinex("CETUSDT", "CETUSDT")
-		if HasNull(last1, last2, last3, last4, last5, last6, last7, last8) {
-			text = "查询失败，请重试"
-		} else {
-			out := Output2(last1, last2, last3, last4, last5, last6, last7, last8)
-			msg := fmt.Sprintf("Coinex: \n%s\n", out)
-			text = msg
+		markets := fetchMarkets(exchangeSources[COINEX])
+		text := T(defaultLang, "error.query")
+		if !HasNull(markets...) {
+			text = formatExchange(COINEX, markets, nil, defaultLang)
 		}
-		c.String(http.StatusOK, text)
+		c.String(http.StatusOK, plainText(text))
 	})
 	r.Run("0.0.0.0:9999") // listen and serve on 0.0.0.0:8080
 }
+
+//configFile 配置文件, /reload 时重新读取
+const configFile = "./conf/bot.yml"
+
+//appConfig 当前配置, /reload 时整体替换, 通过currentConfig读取
+var appConfig atomic.Value
+
+//currentConfig 当前配置的快照, 不要修改返回的配置
+func currentConfig() *Config {
+	c, _ := appConfig.Load().(*Config)
+	return c
+}
+
 func main() {
 
 	c := NewConfig()
 
-	err := config.ParseConfig(c, "./conf/bot.yml")
+	err := config.ParseConfig(c, configFile)
 	if err != nil {
 		return
 	}
+	appConfig.Store(c)
+
+	if len(os.Args) > 1 && os.Args[1] == "subs" {
+		if err = subsCommand(c, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	logConfig, err := utils.LoadLogConfig("./conf/log.yml")
 	if err != nil {
@@ -547,331 +517,89 @@ func main() {
 	}
 	c.Log = logConfig
 
-	subscriptionFile = "config/subscription.gob"
-	loadSubscription(subscriptionFile)
+	store, err := OpenBoltStore(c.App.DB)
+	if err != nil {
+		log.Error("open subscription store failed.", err)
+		return
+	}
+	defer store.Close()
+	if n, err := store.ImportGob("config/subscription.gob"); err != nil {
+		log.Error("import config/subscription.gob failed.", err)
+		return
+	} else if n > 0 {
+		log.Info("imported %d subscriptions from config/subscription.gob", n)
+	}
+	portfolios = store
+	chatSettings = store
+	conversations = store
+	if c.App.FXFile != "" {
+		rates, err := LoadRateFile(c.App.FXFile)
+		if err != nil {
+			log.Error("load fx rate file failed.", err)
+			return
+		}
+		setRateSource(rates)
+	}
+	subscriptions, err = NewSubscriptionManager(store)
+	if err != nil {
+		log.Error("load subscription failed.", err)
+		return
+	}
+	if n, err := store.ImportQuietGob("config/quiet.gob"); err != nil {
+		log.Error("import config/quiet.gob failed.", err)
+		return
+	} else if n > 0 {
+		log.Info("imported %d quiet hours from config/quiet.gob", n)
+	}
 
 	tempBot, err := tb.NewBot(c.App.Botkey)
 	if err != nil {
 		log.Error(err)
 	}
 	bot = tempBot
+	callbacks.SetKey(c.App.Botkey)
+	commands.Fallback = c.App.Fallback
+	commands.Limiter = NewRateLimiter(c.App)
+	callbacks.Limiter = commands.Limiter
+	if err = bot.SetMyCommands(commands.BotCommands()); err != nil {
+		log.Error("set bot commands failed.", err)
+	}
+	scheduler = NewScheduler(alertWorkers, runAlert)
+	outbox = NewOutbox(store, sendOutbound, disableChat, migrateChat)
+	go outbox.Start(nil)
 	go alert()
-	messages := make(chan tb.Message, 100)
+	bot.Messages = make(chan tb.Message, 100)
+	bot.Callbacks = make(chan tb.Callback, 100)
+	bot.Queries = make(chan tb.Query, 100)
 
-	bot.Listen(messages, 10*time.Second)
+	go bot.Start(10 * time.Second)
 	go web()
-	for message := range messages {
-		log.Info("%v", message.Text)
-		res := strings.Split(message.Text, "@")
-		if len(res) > 0 && len(res[0]) > 0 {
-			arr := strings.Split(res[0], " ")
-			if arr[0] == "/alertbtc" {
-				key := fmt.Sprintf("%s-%d", BTC, message.Chat.ID)
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-
-				ns := NewSubscription(BTC, 1, 3600)
-				ns.Chat = chat
-				tgSubscription[key] = ns
-				saveSubscription()
-				msg := "订阅btc提醒成功,间隔1小时"
-				log.Info(msg)
-				bot.SendMessage(message.Chat, msg, nil)
-			} else if arr[0] == "/alertbch" {
-				key := fmt.Sprintf("%s-%d", BCH, message.Chat.ID)
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-				ns := NewSubscription(BCH, 1, 3600)
-				ns.Chat = chat
-				tgSubscription[key] = ns
-				saveSubscription()
-
-				msg := "订阅bch提醒成功,间隔1小时"
-				log.Info(msg)
-				bot.SendMessage(message.Chat, msg, nil)
-
-			} else if arr[0] == "/alertcoinex" {
-				key := fmt.Sprintf("%s-%d", COINEX, message.Chat.ID)
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-				ns := NewSubscription(COINEX, 1, 3600)
-				ns.Chat = chat
-				tgSubscription[key] = ns
-				saveSubscription()
-
-				msg := "订阅bch提醒成功,间隔1小时"
-				log.Info(msg)
-				bot.SendMessage(message.Chat, msg, nil)
-
-			} else if arr[0] == "/alertrange78" {
-				key := fmt.Sprintf("%s%s-%d", BTC, BCH, message.Chat.ID)
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-				ns := NewSubscription(BCH, 2, 600)
-				ns.Chat = chat
-				tgSubscription[key] = ns
-
-				btcm := bitstamp("btcusd", BTC)
-				bchm := bitstamp("bchusd", BCH)
-				ns.BTCPrice = btcm.Last
-				ns.BCHPrice = bchm.Last
-				saveSubscription()
-
-				msg := fmt.Sprintf("订阅BCH,BTC行情大波动提醒成功，七上八下模式开启 BTC %.2f BCH %.2f", btcm.Last, bchm.Last)
-				log.Info(msg)
-				bot.SendMessage(message.Chat, msg, nil)
-			} else if arr[0] == "/dalertbtc" {
-				key := fmt.Sprintf("%s-%d", BTC, message.Chat.ID)
-
-				delete(tgSubscription, key)
-				saveSubscription()
-				bot.SendMessage(message.Chat, "取消订阅btc成功,不再提醒", nil)
-			} else if arr[0] == "/dalertbch" {
-				key := fmt.Sprintf("%s-%d", BCH, message.Chat.ID)
-				delete(tgSubscription, key)
-				saveSubscription()
-				bot.SendMessage(message.Chat, "取消订阅bch成功,不再提醒", nil)
-
-			} else if arr[0] == "/dalertcoinex" {
-				key := fmt.Sprintf("%s-%d", COINEX, message.Chat.ID)
-				delete(tgSubscription, key)
-				saveSubscription()
-				bot.SendMessage(message.Chat, "取消订阅bch成功,不再提醒", nil)
-
-			} else if arr[0] == "/dalertrange78" {
-				key := fmt.Sprintf("%s%s-%d", BTC, BCH, message.Chat.ID)
-				delete(tgSubscription, key)
-				saveSubscription()
-
-				msg := "取消订阅BCH,BTC行情大波动提醒成功，七上八下模式关闭"
-				log.Info(msg)
-				bot.SendMessage(message.Chat, msg, nil)
-
-			} else if arr[0] == "/hi" {
-				bot.SendMessage(message.Chat, "Hello, "+message.Sender.FirstName+" ! \ndonated bch adress : 32LSbGXhDjUie578wGFPVUhK2M7boNcTsB", nil)
-			} else if arr[0] == "/btc" {
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-				doBTC(chat)
-
-			} else if arr[0] == "/bch" {
-				str, _ := json.Marshal(message.Chat)
-				chat := new(tb.Chat)
-				_ = json.Unmarshal(str, chat)
-				doBCH(chat)
-
-			} else if arr[0] == "/ltc" {
-				last1 := bitstamp("ltcusd", LTC)
-				account := poloniex()
-				last2 := account.LTC
-				last3 := bittrex("USDT-LTC", LTC)
-				last4 := bitfinex("tLTCUSD", LTC)
-				last5 := Binance("LTCUSDT", LTC)
-				last6 := coinex("LTCUSDT", LTC)
-				if HasNull(last1, last2, last3, last4, last5, last6) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					min := Minimum(last1, last2, last3, last4, last5, last6)
-					max := Maximum(last1, last2, last3, last4, last5, last6)
-					agiotage := max.Last - min.Last
-					per := agiotage / min.Last * 100
-					out := Output(last1, last2, last3, last4, last5, last6)
-					msg := fmt.Sprintf("LTC \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/eth" {
-				last1 := bitstamp("ethusd", ETH)
-				account := poloniex()
-				last2 := account.ETH
-				last3 := bittrex("USDT-ETH", ETH)
-				last4 := bitfinex("tETHUSD", ETH)
-				last5 := Binance("ETHUSDT", ETH)
-				last6 := coinex("ETHUSDT", ETH)
-				if HasNull(last1, last2, last3, last4, last5, last6) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					min := Minimum(last1, last2, last3, last4, last5, last6)
-					max := Maximum(last1, last2, last3, last4, last5, last6)
-					agiotage := max.Last - min.Last
-					per := agiotage / min.Last * 100
-					out := Output(last1, last2, last3, last4, last5, last6)
-					msg := fmt.Sprintf("ETH \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/bchbtc" {
-
-				account := poloniex()
-				last1 := account.BCHBTC
-				last2 := bittrex("BTC-BCH", BCHBTC)
-				last3 := bitfinex("tBABBTC", BCHBTC)
-				last4 := bitstamp("bchbtc", BCHBTC)
-				last5 := Binance("BCHABCBTC", BCHBTC)
-
-				if HasNull(last1, last2, last3, last4, last5) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					min := Minimum(last1, last2, last3, last4, last5)
-					max := Maximum(last1, last2, last3, last4, last5)
-					agiotage := max.Last - min.Last
-					per := agiotage / min.Last * 100
-					out := Output(last1, last2, last3, last4, last5)
-					msg := fmt.Sprintf("BCHBTC \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/ltcbtc" {
-				last1 := bitstamp("ltcbtc", LTCBTC)
-				account := poloniex()
-				last2 := account.LTCBTC
-				last3 := bittrex("BTC-LTC", LTCBTC)
-				last4 := bitfinex("tLTCBTC", LTCBTC)
-				last5 := Binance("LTCBTC", LTCBTC)
-				last6 := coinex("LTCBTC", LTCBTC)
-				if HasNull(last1, last2, last3, last4, last5, last6) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					min := Minimum(last1, last2, last3, last4, last5, last6)
-					max := Maximum(last1, last2, last3, last4, last5, last6)
-					agiotage := max.Last - min.Last
-					per := agiotage / min.Last * 100
-					out := Output(last1, last2, last3, last4, last5, last6)
-					msg := fmt.Sprintf("LTCBTC \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/ethbtc" {
-				last1 := bitstamp("ethbtc", ETHBTC)
-				account := poloniex()
-				last2 := account.ETHBTC
-				last3 := bittrex("BTC-ETH", ETHBTC)
-				last4 := bitfinex("tETHBTC", ETHBTC)
-				last5 := Binance("ETHBTC", ETHBTC)
-				last6 := coinex("ETHBTC", ETHBTC)
-				if HasNull(last1, last2, last3, last4, last5, last6) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					min := Minimum(last1, last2, last3, last4, last5, last6)
-					max := Maximum(last1, last2, last3, last4, last5, last6)
-					agiotage := max.Last - min.Last
-					per := agiotage / min.Last * 100
-					out := Output(last1, last2, last3, last4, last5, last6)
-					msg := fmt.Sprintf("ETHBTC \n%s\nmax: [%.2f] [%s]\nmin: [%.2f] [%s]\nagiotage:[%.2f][%.2f%%]", out, max.Last, max.Name, min.Last, min.Name, agiotage, per)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/bitstamp" {
-				last := bitstamp("btcusd", BTC)
-				last2 := bitstamp("ltcusd", LTC)
-				last3 := bitstamp("ethusd", ETH)
-				last4 := bitstamp("ltcbtc", LTCBTC)
-				last5 := bitstamp("ethbtc", ETHBTC)
-				last6 := bitstamp("bchusd", BCH)
-				last7 := bitstamp("bchbtc", BCHBTC)
-				if HasNull(last, last2, last3, last4, last5, last6, last7) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					out := Output2(last, last2, last3, last4, last5, last6, last7)
-					msg := fmt.Sprintf("Bitstamp: \n%s", out)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/poloniex" {
-				account := poloniex()
-				if account == nil {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					out := Output2(account.BTC, account.BCH, account.LTC, account.ETH, account.BCHBTC, account.LTCBTC, account.ETHBTC)
-
-					msg := fmt.Sprintf("Poloniex: \n%s\n", out)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-
-			} else if arr[0] == "/bittrex" {
-
-				last1 := bittrex("USDT-BTC", BTC)
-				last2 := bittrex("USDT-BCH", BCH)
-				last3 := bittrex("USDT-LTC", LTC)
-				last4 := bittrex("USDT-ETH", ETH)
-				last5 := bittrex("BTC-BCH", BCHBTC)
-				last6 := bittrex("BTC-LTC", LTCBTC)
-				last7 := bittrex("BTC-ETH", ETHBTC)
-				if HasNull(last1, last2, last3, last4, last5, last6, last7) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					out := Output2(last1, last2, last3, last4, last5, last6, last7)
-					msg := fmt.Sprintf("Bittrex: \n%s\n", out)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-			} else if arr[0] == "/bitfinex" {
-
-				last1 := bitfinex("tBTCUSD", BTC)
-				last2 := bitfinex("tBABUSD", BCH)
-				last3 := bitfinex("tLTCUSD", LTC)
-				last4 := bitfinex("tETHUSD", ETH)
-				last5 := bitfinex("tBABBTC", BCHBTC)
-				last6 := bitfinex("tLTCBTC", LTCBTC)
-				last7 := bitfinex("tETHBTC", ETHBTC)
-				if HasNull(last1, last2, last3, last4, last5, last6, last7) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					out := Output2(last1, last2, last3, last4, last5, last6, last7)
-					msg := fmt.Sprintf("Bitfinex: \n%s\n", out)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-			} else if arr[0] == "/binance" {
-
-				last1 := Binance("BTCUSDT", BTC)
-				last2 := Binance("BCHABCUSDT", BCH)
-				last3 := Binance("LTCUSDT", LTC)
-				last4 := Binance("ETHUSDT", ETH)
-				last5 := Binance("BCHABCBTC", BCHBTC)
-				last6 := Binance("LTCBTC", LTCBTC)
-				last7 := Binance("ETHBTC", ETHBTC)
-				if HasNull(last1, last2, last3, last4, last5, last6, last7) {
-					bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-				} else {
-					out := Output2(last1, last2, last3, last4, last5, last6, last7)
-					msg := fmt.Sprintf("Binance: \n%s\n", out)
-					bot.SendMessage(message.Chat, msg, nil)
-					log.Info(msg)
-				}
-			} else if arr[0] == "/coinex" {
-				if len(arr) > 1 {
-					last1 := coinex(arr[1], arr[1])
-					if HasNull(last1) {
-						bot.SendMessage(message.Chat, "查询失败，请重试", nil)
-					} else {
-						out := Output2(last1)
-						msg := fmt.Sprintf("Coinex: \n%s\n", out)
-						bot.SendMessage(message.Chat, msg, nil)
-						log.Info(msg)
-					}
-				} else {
-					str, _ := json.Marshal(message.Chat)
-					chat := new(tb.Chat)
-					_ = json.Unmarshal(str, chat)
-					doCoinex(chat)
-				}
-			} else {
-				bot.SendMessage(message.Chat, "你等着，我等会找着了给你", nil)
-			}
+	for {
+		select {
+		case message := <-bot.Messages:
+			handleMessage(message)
+		case cb := <-bot.Callbacks:
+			callbacks.Dispatch(cb)
+		case q := <-bot.Queries:
+			go answerInline(q)
 		}
 	}
 
 }
+
+//handleMessage 处理群组迁移和命令
+func handleMessage(message tb.Message) {
+	log.Info("%v", message.Text)
+	if message.MigrateTo != 0 {
+		migrateChat(message.Chat.ID, message.MigrateTo)
+		return
+	}
+	if message.MigrateFrom != 0 {
+		migrateChat(message.MigrateFrom, message.Chat.ID)
+		return
+	}
+	if continueConversation(message) {
+		return
+	}
+	commands.Dispatch(message, bot.Identity.Username)
+}
@@ -10,6 +10,6 @@ func TestBinance(t *testing.T) {
 	fmt.Println(m.Name)
 }
 func TestCoinex(t *testing.T) {
-	m := coinex("BCHUSDT", BCC)
+	m := coinex("BCHUSDT", BCH)
 	fmt.Println(m.Last)
 }
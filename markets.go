@@ -0,0 +1,248 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+)
+
+//quoteSources 每个币种在各交易所对USD(T)的行情查询
+var quoteSources = map[string][]func() *Market{
+	BTC: {
+		func() *Market { return bitstamp("btcusd", BTC) },
+		func() *Market { return poloniexMarket(BTC) },
+		func() *Market { return bittrex("USDT-BTC", BTC) },
+		func() *Market { return bitfinex("tBTCUSD", BTC) },
+		func() *Market { return Binance("BTCUSDT", BTC) },
+		func() *Market { return coinex("BTCUSDT", BTC) },
+	},
+	BCH: {
+		func() *Market { return poloniexMarket(BCH) },
+		func() *Market { return bittrex("USDT-BCH", BCH) },
+		func() *Market { return bitfinex("tBABUSD", BCH) },
+		func() *Market { return bitstamp("bchusd", BCH) },
+		func() *Market { return Binance("BCHABCUSDT", BCH) },
+		func() *Market { return coinex("BCHUSDT", BCH) },
+	},
+	LTC: {
+		func() *Market { return bitstamp("ltcusd", LTC) },
+		func() *Market { return poloniexMarket(LTC) },
+		func() *Market { return bittrex("USDT-LTC", LTC) },
+		func() *Market { return bitfinex("tLTCUSD", LTC) },
+		func() *Market { return Binance("LTCUSDT", LTC) },
+		func() *Market { return coinex("LTCUSDT", LTC) },
+	},
+	ETH: {
+		func() *Market { return bitstamp("ethusd", ETH) },
+		func() *Market { return poloniexMarket(ETH) },
+		func() *Market { return bittrex("USDT-ETH", ETH) },
+		func() *Market { return bitfinex("tETHUSD", ETH) },
+		func() *Market { return Binance("ETHUSDT", ETH) },
+		func() *Market { return coinex("ETHUSDT", ETH) },
+	},
+}
+
+//crossSources 每个币种在各交易所对BTC的行情查询
+var crossSources = map[string][]func() *Market{
+	BCHBTC: {
+		func() *Market { return poloniexMarket(BCHBTC) },
+		func() *Market { return bittrex("BTC-BCH", BCHBTC) },
+		func() *Market { return bitfinex("tBABBTC", BCHBTC) },
+		func() *Market { return bitstamp("bchbtc", BCHBTC) },
+		func() *Market { return Binance("BCHABCBTC", BCHBTC) },
+	},
+	LTCBTC: {
+		func() *Market { return bitstamp("ltcbtc", LTCBTC) },
+		func() *Market { return poloniexMarket(LTCBTC) },
+		func() *Market { return bittrex("BTC-LTC", LTCBTC) },
+		func() *Market { return bitfinex("tLTCBTC", LTCBTC) },
+		func() *Market { return Binance("LTCBTC", LTCBTC) },
+		func() *Market { return coinex("LTCBTC", LTCBTC) },
+	},
+	ETHBTC: {
+		func() *Market { return bitstamp("ethbtc", ETHBTC) },
+		func() *Market { return poloniexMarket(ETHBTC) },
+		func() *Market { return bittrex("BTC-ETH", ETHBTC) },
+		func() *Market { return bitfinex("tETHBTC", ETHBTC) },
+		func() *Market { return Binance("ETHBTC", ETHBTC) },
+		func() *Market { return coinex("ETHBTC", ETHBTC) },
+	},
+}
+
+//exchangeNames 按钮等需要固定顺序时的交易所列表
+var exchangeNames = []string{BITSTAMP, POLONIEX, BITTREX, BITFINEX, BINANCE, COINEX}
+
+//exchangeSources 每个交易所的全部行情查询
+var exchangeSources = map[string][]func() *Market{
+	BITSTAMP: {
+		func() *Market { return bitstamp("btcusd", BTC) },
+		func() *Market { return bitstamp("ltcusd", LTC) },
+		func() *Market { return bitstamp("ethusd", ETH) },
+		func() *Market { return bitstamp("ltcbtc", LTCBTC) },
+		func() *Market { return bitstamp("ethbtc", ETHBTC) },
+		func() *Market { return bitstamp("bchusd", BCH) },
+		func() *Market { return bitstamp("bchbtc", BCHBTC) },
+	},
+	POLONIEX: {
+		func() *Market { return poloniexMarket(BTC) },
+		func() *Market { return poloniexMarket(BCH) },
+		func() *Market { return poloniexMarket(LTC) },
+		func() *Market { return poloniexMarket(ETH) },
+		func() *Market { return poloniexMarket(BCHBTC) },
+		func() *Market { return poloniexMarket(LTCBTC) },
+		func() *Market { return poloniexMarket(ETHBTC) },
+	},
+	BITTREX: {
+		func() *Market { return bittrex("USDT-BTC", BTC) },
+		func() *Market { return bittrex("USDT-BCH", BCH) },
+		func() *Market { return bittrex("USDT-LTC", LTC) },
+		func() *Market { return bittrex("USDT-ETH", ETH) },
+		func() *Market { return bittrex("BTC-BCH", BCHBTC) },
+		func() *Market { return bittrex("BTC-LTC", LTCBTC) },
+		func() *Market { return bittrex("BTC-ETH", ETHBTC) },
+	},
+	BITFINEX: {
+		func() *Market { return bitfinex("tBTCUSD", BTC) },
+		func() *Market { return bitfinex("tBABUSD", BCH) },
+		func() *Market { return bitfinex("tLTCUSD", LTC) },
+		func() *Market { return bitfinex("tETHUSD", ETH) },
+		func() *Market { return bitfinex("tBABBTC", BCHBTC) },
+		func() *Market { return bitfinex("tLTCBTC", LTCBTC) },
+		func() *Market { return bitfinex("tETHBTC", ETHBTC) },
+	},
+	BINANCE: {
+		func() *Market { return Binance("BTCUSDT", BTC) },
+		func() *Market { return Binance("BCHABCUSDT", BCH) },
+		func() *Market { return Binance("LTCUSDT", LTC) },
+		func() *Market { return Binance("ETHUSDT", ETH) },
+		func() *Market { return Binance("BCHABCBTC", BCHBTC) },
+		func() *Market { return Binance("LTCBTC", LTCBTC) },
+		func() *Market { return Binance("ETHBTC", ETHBTC) },
+	},
+	COINEX: {
+		func() *Market { return coinex("BTCUSDT", BTC) },
+		func() *Market { return coinex("BCHUSDT", BCH) },
+		func() *Market { return coinex("LTCUSDT", LTC) },
+		func() *Market { return coinex("ETHUSDT", ETH) },
+		func() *Market { return coinex("BCHBTC", BCHBTC) },
+		func() *Market { return coinex("LTCBTC", LTCBTC) },
+		func() *Market { return coinex("ETHBTC", ETHBTC) },
+		func() *Market { return coinex("CETUSDT", "CETUSDT") },
+	},
+}
+
+//stableSources 稳定币对USD的行情查询
+var stableSources = map[string][]func() *Market{
+	USDT: {
+		func() *Market { return bitstamp("usdtusd", USDT) },
+		func() *Market { return bitfinex("tUSTUSD", USDT) },
+	},
+	USDC: {
+		func() *Market { return bitstamp("usdcusd", USDC) },
+		func() *Market { return bitfinex("tUDCUSD", USDC) },
+	},
+}
+
+//compareSources 币种对比行情的查询, 包括对USD(T)和对BTC
+func compareSources(symbol string) ([]func() *Market, bool) {
+	if sources, ok := quoteSources[symbol]; ok {
+		return sources, true
+	}
+	sources, ok := crossSources[symbol]
+	return sources, ok
+}
+
+//poloniexMarket 从poloniex全量行情中取出单个币种
+func poloniexMarket(trader string) *Market {
+	account := poloniex()
+	if account == nil {
+		return nil
+	}
+	switch trader {
+	case BTC:
+		return account.BTC
+	case BCH:
+		return account.BCH
+	case LTC:
+		return account.LTC
+	case ETH:
+		return account.ETH
+	case BCHBTC:
+		return account.BCHBTC
+	case LTCBTC:
+		return account.LTCBTC
+	case ETHBTC:
+		return account.ETHBTC
+	}
+	return nil
+}
+
+//fetchMarkets 并发查询所有行情, 查询失败的位置为nil
+func fetchMarkets(sources []func() *Market) []*Market {
+	markets := make([]*Market, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source func() *Market) {
+			defer wg.Done()
+			//接口返回异常数据时只算这一家查询失败, 不让整个进程退出
+			defer func() {
+				if err := recover(); err != nil {
+					log.Error("fetch market panic: %v", err)
+				}
+			}()
+			markets[i] = source()
+		}(i, source)
+	}
+	wg.Wait()
+	return markets
+}
+
+//referencePrice 币种的跨交易所参考价(中位数), USDT/USDC报价先按稳定币汇率换算成USD, 交叉盘为BTC计价
+func referencePrice(symbol string) (float64, error) {
+	sources, ok := compareSources(symbol)
+	if !ok {
+		return 0, errors.Errorf("unsupported symbol %s", symbol)
+	}
+	return medianPrice(symbol, fetchMarkets(sources), stableRate)
+}
+
+//medianPrice 报价的中位数, USDT/USDC报价按stable换算成USD后再比较
+func medianPrice(symbol string, markets []*Market, stable func(asset string) (float64, error)) (float64, error) {
+	rates := make(map[string]float64)
+	var prices []float64
+	for _, m := range markets {
+		if m == nil || m.Last <= 0 {
+			continue
+		}
+		last := m.Last
+		if isStablecoin(m.Quote) {
+			r, ok := rates[m.Quote]
+			if !ok {
+				var err error
+				if r, err = stable(m.Quote); err != nil {
+					return 0, errors.Wrapf(err, "%s/USD", m.Quote)
+				}
+				rates[m.Quote] = r
+			}
+			last *= r
+		}
+		prices = append(prices, last)
+	}
+	if len(prices) == 0 {
+		return 0, errors.Errorf("no price for %s", symbol)
+	}
+	return median(prices), nil
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+const (
+	//outboxMaxAttempts 超过重试次数后进入死信列表
+	outboxMaxAttempts = 10
+	//outboxBaseDelay outboxMaxDelay 重试间隔, 按次数指数增长
+	outboxBaseDelay = 5 * time.Second
+	outboxMaxDelay  = 30 * time.Minute
+	//outboxPollInterval 定时检查待重试消息的间隔
+	outboxPollInterval = 5 * time.Second
+)
+
+//OutboundMessage 待投递的提醒消息
+type OutboundMessage struct {
+	ID     uint64
+	ChatID int64
+	Text   string
+	//HTML Text为HTML格式
+	HTML      bool
+	Attempts  int
+	NextTry   int
+	LastError string
+	Created   int
+}
+
+//OutboxStore 投递队列持久化接口
+type OutboxStore interface {
+	//EnqueueMessages 在同一事务内写入消息并分配ID
+	EnqueueMessages(msgs ...*OutboundMessage) error
+	//PendingMessages 按ID顺序返回全部待投递消息
+	PendingMessages() ([]*OutboundMessage, error)
+	UpdateMessage(msg *OutboundMessage) error
+	//AckMessage 投递成功后删除消息
+	AckMessage(id uint64) error
+	//BuryMessage 把消息移入死信列表
+	BuryMessage(msg *OutboundMessage) error
+	DeadLetters() ([]*OutboundMessage, error)
+}
+
+//chatGoneErrors 表示聊天已不可达的api错误, 重试没有意义
+var chatGoneErrors = []string{
+	"bot was blocked by the user",
+	"chat not found",
+	"user is deactivated",
+	"bot was kicked",
+	"bot is not a member",
+}
+
+//isChatGone 错误是否表示聊天已不可达
+func isChatGone(err error) bool {
+	if err == nil {
+		return false
+	}
+	text := err.Error()
+	for _, s := range chatGoneErrors {
+		if strings.Contains(text, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//migratedTo 群组升级为超级群组后api返回的新聊天ID
+func migratedTo(err error) int64 {
+	if apiErr, ok := err.(*tb.APIError); ok {
+		return apiErr.Parameters.MigrateTo
+	}
+	return 0
+}
+
+//retryDelay 第attempts次失败后的等待时间
+func retryDelay(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxDelay {
+		delay = outboxMaxDelay
+	}
+	return delay
+}
+
+//Outbox 带重试的提醒投递队列, 消息先持久化再发送, 重启后继续投递
+type Outbox struct {
+	store OutboxStore
+	send  func(msg *OutboundMessage) error
+	//gone 聊天不可达时回调, 用于停用该聊天的订阅
+	gone func(chatID int64, err error)
+	//migrate 群组已升级为超级群组时回调, 用于迁移该聊天的订阅
+	migrate func(from int64, to int64)
+	wake    chan struct{}
+}
+
+//NewOutbox create NewOutbox
+func NewOutbox(store OutboxStore, send func(msg *OutboundMessage) error, gone func(chatID int64, err error), migrate func(from int64, to int64)) *Outbox {
+	return &Outbox{
+		store:   store,
+		send:    send,
+		gone:    gone,
+		migrate: migrate,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+//Send 把消息放入投递队列
+func (o *Outbox) Send(chatID int64, text string) error {
+	return o.enqueue(&OutboundMessage{ChatID: chatID, Text: text})
+}
+
+//SendHTML 把HTML消息放入投递队列, 超长时拆成多条, 全部写入或全部不写入
+func (o *Outbox) SendHTML(chatID int64, texts ...string) error {
+	var msgs []*OutboundMessage
+	for _, text := range texts {
+		for _, part := range splitMessage(text, messageLimit) {
+			msgs = append(msgs, &OutboundMessage{ChatID: chatID, Text: part, HTML: true})
+		}
+	}
+	return o.enqueue(msgs...)
+}
+
+func (o *Outbox) enqueue(msgs ...*OutboundMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := LocalSecond()
+	for _, msg := range msgs {
+		msg.NextTry = now
+		msg.Created = now
+	}
+	if err := o.store.EnqueueMessages(msgs...); err != nil {
+		return err
+	}
+	o.kick()
+	return nil
+}
+
+func (o *Outbox) kick() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+//Flush 投递所有到期的消息, 同一聊天的消息按顺序投递, 前一条失败时后面的等待下次
+func (o *Outbox) Flush() {
+	pending, err := o.store.PendingMessages()
+	if err != nil {
+		log.Error("load outbox failed.", err)
+		return
+	}
+
+	now := LocalSecond()
+	blocked := make(map[int64]bool)
+	for _, msg := range pending {
+		if blocked[msg.ChatID] {
+			continue
+		}
+		if msg.NextTry > now {
+			blocked[msg.ChatID] = true
+			continue
+		}
+
+		err := o.send(msg)
+		if err == nil {
+			if err = o.store.AckMessage(msg.ID); err != nil {
+				log.Error("ack outbox message failed.", err)
+			}
+			continue
+		}
+
+		if to := migratedTo(err); to != 0 {
+			//群组已升级, 改投新聊天并立即重试
+			log.Info("chat %d migrated to %d", msg.ChatID, to)
+			if o.migrate != nil {
+				o.migrate(msg.ChatID, to)
+			}
+			from := msg.ChatID
+			for _, m := range pending {
+				if m.ChatID == from {
+					m.ChatID = to
+					if err := o.store.UpdateMessage(m); err != nil {
+						log.Error("update outbox message failed.", err)
+					}
+				}
+			}
+			blocked[from] = true
+			blocked[to] = true
+			o.kick()
+			continue
+		}
+
+		blocked[msg.ChatID] = true
+		msg.Attempts++
+		msg.LastError = err.Error()
+		if isChatGone(err) || msg.Attempts >= outboxMaxAttempts {
+			log.Error("outbox message to %d dead: %s", msg.ChatID, msg.LastError)
+			if err := o.store.BuryMessage(msg); err != nil {
+				log.Error("bury outbox message failed.", err)
+			}
+			if isChatGone(err) && o.gone != nil {
+				o.gone(msg.ChatID, err)
+			}
+			continue
+		}
+		msg.NextTry = now + int(retryDelay(msg.Attempts)/time.Second)
+		log.Info("outbox message to %d failed (attempt %d), retry at %d: %s", msg.ChatID, msg.Attempts, msg.NextTry, msg.LastError)
+		if err := o.store.UpdateMessage(msg); err != nil {
+			log.Error("update outbox message failed.", err)
+		}
+	}
+}
+
+//Start 循环投递队列中的消息, 阻塞直到stop关闭
+func (o *Outbox) Start(stop <-chan struct{}) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		o.Flush()
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+//sendOutbound 通过bot发送队列中的消息
+func sendOutbound(msg *OutboundMessage) error {
+	var options *tb.SendOptions
+	if msg.HTML {
+		options = htmlOptions
+	}
+	return bot.SendMessage(&tb.Chat{ID: msg.ChatID}, msg.Text, options)
+}
+
+//migrateChat 群组升级为超级群组后迁移该聊天的订阅和设置
+func migrateChat(from int64, to int64) {
+	if from == 0 || to == 0 || from == to {
+		return
+	}
+	moved, err := subscriptions.Rekey(from, to)
+	if err != nil {
+		log.Error("rekey subscriptions failed.", err)
+	}
+	for oldKey, newKey := range moved {
+		scheduler.Cancel(oldKey)
+		if sub, ok := subscriptions.Get(newKey); ok {
+			scheduleSubscription(newKey, sub)
+		}
+	}
+	if err = chatSettings.MoveChatSettings(from, to); err != nil {
+		log.Error("move chat settings failed.", err)
+	}
+	log.Info("chat %d migrated to %d, %d subscriptions moved", from, to, len(moved))
+}
+
+//disableChat 聊天不可达时停用该聊天的所有订阅
+func disableChat(chatID int64, reason error) {
+	for k, sub := range subscriptions.List() {
+		if sub.ChatID != chatID || sub.Disabled {
+			continue
+		}
+		log.Info("disable subscription %s: %v", k, reason)
+		updateSubscription(k, func(s *Subscription) { s.Disabled = true })
+		scheduler.Cancel(k)
+	}
+}
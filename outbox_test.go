@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	tb "tg.robot/telebot"
+)
+
+func TestOutboxRetryAndDeadLetter(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var sent []string
+	var gone []int64
+	failures := map[int64]error{
+		1: errors.New("api error: Forbidden: bot was blocked by the user"),
+		2: errors.New("http.Post failed: timeout"),
+	}
+	o := NewOutbox(store, func(msg *OutboundMessage) error {
+		if err := failures[msg.ChatID]; err != nil {
+			return err
+		}
+		sent = append(sent, msg.Text)
+		return nil
+	}, func(chatID int64, err error) {
+		gone = append(gone, chatID)
+	}, nil)
+
+	o.Send(1, "blocked")
+	o.Send(2, "first")
+	o.Send(2, "second")
+	o.Send(3, "ok")
+	o.Flush()
+
+	if len(sent) != 1 || sent[0] != "ok" {
+		t.Fatalf("unexpected sent %v", sent)
+	}
+	if len(gone) != 1 || gone[0] != 1 {
+		t.Fatalf("unexpected gone chats %v", gone)
+	}
+	dead, _ := store.DeadLetters()
+	if len(dead) != 1 || dead[0].Text != "blocked" {
+		t.Fatalf("unexpected dead letters %v", dead)
+	}
+
+	pending, _ := store.PendingMessages()
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[1].Attempts != 0 {
+		t.Fatalf("temporary failure should be retried in order, got %v", pending)
+	}
+
+	//恢复后按原顺序投递
+	delete(failures, 2)
+	pending[0].NextTry = 0
+	store.UpdateMessage(pending[0])
+	o.Flush()
+	if len(sent) != 3 || sent[1] != "first" || sent[2] != "second" {
+		t.Fatalf("unexpected sent %v", sent)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if retryDelay(1) != outboxBaseDelay || retryDelay(2) != 2*outboxBaseDelay {
+		t.Fatal("retry delay should grow exponentially")
+	}
+	if retryDelay(100) != outboxMaxDelay {
+		t.Fatal("retry delay should be capped")
+	}
+}
+
+func TestOutboxMigrate(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var sent []int64
+	var from, to int64
+	o := NewOutbox(store, func(msg *OutboundMessage) error {
+		if msg.ChatID == -42 {
+			return &tb.APIError{
+				Code:        400,
+				Description: "Bad Request: group chat was upgraded to a supergroup chat",
+				Parameters:  tb.ResponseParameters{MigrateTo: -10042},
+			}
+		}
+		sent = append(sent, msg.ChatID)
+		return nil
+	}, nil, func(f int64, t int64) {
+		from, to = f, t
+	})
+
+	o.Send(-42, "first")
+	o.Send(-42, "second")
+	o.Flush()
+	o.Flush()
+
+	if from != -42 || to != -10042 {
+		t.Fatalf("migrate hook got %d -> %d", from, to)
+	}
+	if len(sent) != 2 || sent[0] != -10042 || sent[1] != -10042 {
+		t.Fatalf("messages should be resent to the new chat, got %v", sent)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gonethopper/libs/config"
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	tb "tg.robot/telebot"
+)
+
+//broadcastInterval 群发时每条消息的间隔, Telegram限制每秒最多约30条
+const broadcastInterval = 100 * time.Millisecond
+
+//formatAgo 距今多久, 精确到秒
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Truncate(time.Second).String()
+}
+
+//subscribedChats 有启用中订阅的聊天, 按ID排列
+func subscribedChats() []int64 {
+	seen := make(map[int64]bool)
+	var chats []int64
+	for _, sub := range subscriptions.List() {
+		if sub.Disabled || sub.ChatID == 0 || seen[sub.ChatID] {
+			continue
+		}
+		seen[sub.ChatID] = true
+		chats = append(chats, sub.ChatID)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats
+}
+
+//statsText 运行时间、聊天和订阅数量、命令调用次数和各交易所失败率
+func statsText(lang string) string {
+	chats := make(map[int64]bool)
+	total, disabled := 0, 0
+	for _, sub := range subscriptions.List() {
+		chats[sub.ChatID] = true
+		total++
+		if sub.Disabled {
+			disabled++
+		}
+	}
+	if list, err := chatSettings.ListChatSettings(); err != nil {
+		log.Error("list chat settings failed.", err)
+	} else {
+		for _, s := range list {
+			chats[s.ChatID] = true
+		}
+	}
+
+	uptime := stats.Uptime().Truncate(time.Second).String()
+	msg := fmt.Sprintf("%s\n%s", bold(T(lang, "stats.title")), escapeHTML(T(lang, "stats.summary", uptime, len(chats), total, disabled)))
+
+	t := (&Table{}).Row(strings.Split(T(lang, "stats.commands"), "|")...)
+	for _, c := range stats.Commands() {
+		t.Row("/"+c.Name, strconv.Itoa(c.Count))
+	}
+	msg = fmt.Sprintf("%s\n%s", msg, t)
+
+	t = (&Table{}).Row(strings.Split(T(lang, "stats.providers"), "|")...)
+	for _, p := range stats.Providers() {
+		t.Row(p.Name, strconv.Itoa(p.Requests), fmt.Sprintf("%.1f%%", p.ErrorRate()*100))
+	}
+	return fmt.Sprintf("%s\n%s", msg, t)
+}
+
+//exchangesText 各行情接口的状态、请求数、失败率、平均耗时和最近一次错误
+func exchangesText(lang string) string {
+	providers := stats.Providers()
+	if len(providers) == 0 {
+		return escapeHTML(T(lang, "exchanges.none"))
+	}
+	t := (&Table{}).Row(strings.Split(T(lang, "exchanges.columns"), "|")...)
+	var errs []string
+	for _, p := range providers {
+		status := "🟢"
+		if !p.Up() {
+			status = "🔴"
+		}
+		t.Row(p.Name, status, strconv.Itoa(p.Requests), fmt.Sprintf("%.1f%%", p.ErrorRate()*100),
+			p.AvgLatency().Round(time.Millisecond).String(), formatAgo(p.LastOK))
+		if p.LastError != "" {
+			errs = append(errs, escapeHTML(T(lang, "exchanges.error", p.Name, formatAgo(p.LastErrorAt), p.LastError)))
+		}
+	}
+	msg := fmt.Sprintf("%s\n%s", bold(T(lang, "exchanges.title")), t)
+	if len(errs) > 0 {
+		msg = fmt.Sprintf("%s\n%s", msg, strings.Join(errs, "\n"))
+	}
+	return msg
+}
+
+//reloadConfig 重新读取配置文件, 更新管理员、默认回复、汇率文件和频率限制, botkey和db需要重启生效
+func reloadConfig() (*Config, error) {
+	old := currentConfig()
+	c := NewConfig()
+	if err := config.ParseConfig(c, configFile); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", configFile)
+	}
+	//不能在运行中切换的配置沿用旧值
+	c.App.Botkey, c.App.DB, c.Log = old.App.Botkey, old.App.DB, old.Log
+
+	if c.App.FXFile != "" {
+		rates, err := LoadRateFile(c.App.FXFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load %s failed", c.App.FXFile)
+		}
+		setRateSource(rates)
+	} else if old.App.FXFile != "" {
+		setRateSource(NewHTTPRateSource(fxURL))
+	}
+	//整体替换, 其它goroutine读到的总是完整的旧配置或新配置
+	appConfig.Store(c)
+	commands.Fallback = c.App.Fallback
+	if commands.Limiter != nil {
+		commands.Limiter.SetConfig(c.App)
+	}
+	return c, nil
+}
+
+func doStats(message tb.Message, args []string) {
+	sendHTML(&message.Chat, statsText(messageLang(message)))
+}
+
+func doExchanges(message tb.Message, args []string) {
+	sendHTML(&message.Chat, exchangesText(messageLang(message)))
+}
+
+func doReload(message tb.Message, args []string) {
+	lang := messageLang(message)
+	c, err := reloadConfig()
+	if err != nil {
+		log.Error("reload config failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "reload.failed", err), nil)
+		return
+	}
+	fx := c.App.FXFile
+	if fx == "" {
+		fx = fxURL
+	}
+	msg := T(lang, "reload.done", len(c.App.Owners), fx)
+	log.Info(msg)
+	bot.SendMessage(message.Chat, msg, nil)
+}
+
+//doBroadcast /broadcast 通知内容, 按间隔放入投递队列, 保留原消息的换行
+func doBroadcast(message tb.Message, args []string) {
+	lang := messageLang(message)
+	text := strings.TrimSpace(strings.TrimPrefix(message.Text, strings.Fields(message.Text)[0]))
+	chats := subscribedChats()
+	bot.SendMessage(message.Chat, T(lang, "broadcast.started", len(chats)), nil)
+
+	go func(chat tb.Chat) {
+		queued, failed := 0, 0
+		for i, id := range chats {
+			if i > 0 {
+				time.Sleep(broadcastInterval)
+			}
+			if err := outbox.Send(id, text); err != nil {
+				log.Error("queue broadcast failed.", err)
+				failed++
+				continue
+			}
+			queued++
+		}
+		msg := T(lang, "broadcast.done", queued, failed)
+		log.Info(msg)
+		bot.SendMessage(chat, msg, nil)
+	}(message.Chat)
+}
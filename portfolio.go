@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	tb "tg.robot/telebot"
+)
+
+//Holding 单个币种的持仓, Cost为总成本(USD)
+type Holding struct {
+	Symbol string
+	Amount float64
+	Cost   float64
+}
+
+//Portfolio 用户持仓, 按Telegram User.ID保存
+type Portfolio struct {
+	UserID   int
+	Holdings map[string]*Holding
+}
+
+//PortfolioStore 持仓持久化接口
+type PortfolioStore interface {
+	//LoadPortfolio 读取用户持仓, 不存在时返回空持仓
+	LoadPortfolio(userID int) (*Portfolio, error)
+	SavePortfolio(p *Portfolio) error
+}
+
+var portfolios PortfolioStore
+
+//NewPortfolio create NewPortfolio
+func NewPortfolio(userID int) *Portfolio {
+	return &Portfolio{
+		UserID:   userID,
+		Holdings: make(map[string]*Holding),
+	}
+}
+
+//Add 买入(amount>0)或卖出(amount<0), 卖出按平均成本减少成本
+func (p *Portfolio) Add(symbol string, amount float64, price float64) error {
+	h := p.Holdings[symbol]
+	if h == nil {
+		h = &Holding{Symbol: symbol}
+	}
+	if amount >= 0 {
+		h.Amount += amount
+		h.Cost += amount * price
+	} else {
+		if -amount > h.Amount+1e-12 {
+			return errors.Errorf("not enough %s: hold %g", symbol, h.Amount)
+		}
+		h.Cost -= h.Cost * (-amount / h.Amount)
+		h.Amount += amount
+	}
+	if h.Amount <= 1e-12 {
+		delete(p.Holdings, symbol)
+		return nil
+	}
+	p.Holdings[symbol] = h
+	return nil
+}
+
+//Symbols 按名称排序的持仓币种
+func (p *Portfolio) Symbols() []string {
+	symbols := make([]string, 0, len(p.Holdings))
+	for s := range p.Holdings {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+//PortfolioValue 持仓估值
+type PortfolioValue struct {
+	Value float64
+	Cost  float64
+	//Prices 各币种参考价
+	Prices map[string]float64
+}
+
+//PL 盈亏
+func (v *PortfolioValue) PL() float64 {
+	return v.Value - v.Cost
+}
+
+//PLPercent 盈亏百分比
+func (v *PortfolioValue) PLPercent() float64 {
+	if v.Cost == 0 {
+		return 0
+	}
+	return v.PL() / v.Cost * 100
+}
+
+//Valuate 按参考价估值
+func (p *Portfolio) Valuate(price func(symbol string) (float64, error)) (*PortfolioValue, error) {
+	v := &PortfolioValue{Prices: make(map[string]float64)}
+	for _, s := range p.Symbols() {
+		h := p.Holdings[s]
+		last, err := price(s)
+		if err != nil {
+			return nil, err
+		}
+		v.Prices[s] = last
+		v.Value += h.Amount * last
+		v.Cost += h.Cost
+	}
+	return v, nil
+}
+
+//parseHold 解析 /hold BTC 0.5 @ 30000, 价格可省略
+func parseHold(args []string) (string, float64, float64, error) {
+	joined := strings.Join(args, " ")
+	price := 0.0
+	if i := strings.Index(joined, "@"); i >= 0 {
+		p, err := strconv.ParseFloat(strings.TrimSpace(joined[i+1:]), 64)
+		if err != nil || p <= 0 {
+			return "", 0, 0, errors.Errorf("bad price %s", joined[i+1:])
+		}
+		price = p
+		joined = joined[:i]
+	}
+	fields := strings.Fields(joined)
+	if len(fields) != 2 {
+		return "", 0, 0, errors.New("usage: /hold BTC 0.5 @ 30000")
+	}
+	symbol := strings.ToUpper(fields[0])
+	if _, ok := quoteSources[symbol]; !ok {
+		return "", 0, 0, errors.Errorf("unsupported symbol %s", symbol)
+	}
+	amount, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || amount == 0 {
+		return "", 0, 0, errors.Errorf("bad amount %s", fields[1])
+	}
+	return symbol, amount, price, nil
+}
+
+func doHold(message tb.Message, args []string) {
+	lang := messageLang(message)
+	symbol, amount, price, err := parseHold(args)
+	if err != nil {
+		bot.SendMessage(message.Chat, T(lang, "hold.usage"), nil)
+		return
+	}
+	if price == 0 {
+		if price, err = referencePrice(symbol); err != nil {
+			bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+			return
+		}
+	}
+
+	p, err := portfolios.LoadPortfolio(message.Sender.ID)
+	if err != nil {
+		log.Error("load portfolio failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+		return
+	}
+	if err = p.Add(symbol, amount, price); err != nil {
+		bot.SendMessage(message.Chat, T(lang, "hold.notEnough", err), nil)
+		return
+	}
+	if err = portfolios.SavePortfolio(p); err != nil {
+		log.Error("save portfolio failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.save"), nil)
+		return
+	}
+
+	msg := T(lang, "hold.recorded", symbol, amount, price)
+	if h := p.Holdings[symbol]; h != nil {
+		msg = T(lang, "hold.current", msg, symbol, h.Amount, h.Cost/h.Amount)
+	}
+	log.Info(msg)
+	bot.SendMessage(message.Chat, msg, nil)
+}
+
+//portfolioText 持仓明细和盈亏, 返回HTML
+func portfolioText(p *Portfolio, lang string) string {
+	if len(p.Holdings) == 0 {
+		return T(lang, "portfolio.empty")
+	}
+	v, err := p.Valuate(referencePrice)
+	if err != nil {
+		return T(lang, "error.query")
+	}
+	t := (&Table{}).Row(strings.Split(T(lang, "portfolio.columns"), "|")...)
+	for _, s := range p.Symbols() {
+		h := p.Holdings[s]
+		value := h.Amount * v.Prices[s]
+		pl := value - h.Cost
+		per := 0.0
+		if h.Cost > 0 {
+			per = pl / h.Cost * 100
+		}
+		t.Row(s, fmt.Sprintf("%g", h.Amount), formatPrice(v.Prices[s]), fmt.Sprintf("%.2f", h.Cost), fmt.Sprintf("%.2f", value),
+			fmt.Sprintf("%+.2f", pl), fmt.Sprintf("%+.2f%%", per), trendMark(pl))
+	}
+	return T(lang, "portfolio.summary", t.String(), v.Value, v.Cost, v.PL(), v.PLPercent())
+}
+
+func doPortfolio(message tb.Message) {
+	lang := messageLang(message)
+	p, err := portfolios.LoadPortfolio(message.Sender.ID)
+	if err != nil {
+		log.Error("load portfolio failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.query"), nil)
+		return
+	}
+	sendHTML(&message.Chat, portfolioText(p, lang))
+}
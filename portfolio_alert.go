@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+const (
+	//PORTFOLIO 持仓提醒的Trader
+	PORTFOLIO = "Portfolio"
+
+	//portfolioCheckInterval 持仓价位/涨跌提醒的检查间隔(秒)
+	portfolioCheckInterval = 600
+	//portfolioDigestHour 每日摘要默认发送时间
+	portfolioDigestHour = 9
+)
+
+func portfolioLevelKey(userID int, chatID int64) string {
+	return subscriptionKey(fmt.Sprintf("PFLEVEL%d", userID), chatID)
+}
+
+func portfolioMoveKey(userID int, chatID int64) string {
+	return subscriptionKey(fmt.Sprintf("PFMOVE%d", userID), chatID)
+}
+
+func portfolioDigestKey(userID int, chatID int64) string {
+	return subscriptionKey(fmt.Sprintf("PFDIGEST%d", userID), chatID)
+}
+
+//dayStart t所在日期的0点, 日期按t的时区计算
+func dayStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+//nextDigestTime 下一个hour点
+func nextDigestTime(now time.Time, hour int) time.Time {
+	next := dayStart(now).Add(time.Duration(hour) * time.Hour)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+//digestLastTime 使下次提醒在hour点执行的LastTime
+func digestLastTime(now time.Time, hour int, duration int) int {
+	return int(nextDigestTime(now, hour).Unix()) - duration - 1
+}
+
+//portfolioValue 订阅用户当前的持仓估值, 没有持仓时返回nil
+func portfolioValue(sub Subscription) (*Portfolio, *PortfolioValue, bool) {
+	p, err := portfolios.LoadPortfolio(sub.UserID)
+	if err != nil {
+		log.Error("load portfolio failed.", err)
+		return nil, nil, false
+	}
+	if len(p.Holdings) == 0 {
+		return p, nil, true
+	}
+	v, err := p.Valuate(referencePrice)
+	if err != nil {
+		return p, nil, false
+	}
+	return p, v, true
+}
+
+//crossed value从baseline一侧穿越到level另一侧
+func crossed(baseline float64, value float64, level float64) bool {
+	return (baseline < level && value >= level) || (baseline > level && value <= level)
+}
+
+func alertPortfolioLevel(k string, sub Subscription, run *alertRun) bool {
+	_, v, ok := portfolioValue(sub)
+	if !ok || v == nil {
+		return ok
+	}
+	if sub.Baseline > 0 && crossed(sub.Baseline, v.Value, sub.Level) {
+		lang := chatLang(sub.ChatID, nil)
+		msg := T(lang, "portfolio.below", sub.Level, sub.Baseline, v.Value)
+		if v.Value > sub.Baseline {
+			msg = T(lang, "portfolio.above", sub.Level, sub.Baseline, v.Value)
+		}
+		log.Info(msg)
+		run.deliver(trendMark(v.Value-sub.Baseline)+" "+escapeHTML(msg), true)
+	}
+	run.update(func(s *Subscription) { s.Baseline = v.Value })
+	return true
+}
+
+//portfolioMove 相对当日开始时总值的涨跌百分比, 当日已提醒过或未达到Percent时alert为false
+func portfolioMove(sub Subscription, value float64) (change float64, alert bool) {
+	change = (value - sub.Baseline) / sub.Baseline * 100
+	return change, sub.AlertedDay != sub.DayStart && math.Abs(change) >= sub.Percent
+}
+
+func alertPortfolioMove(k string, sub Subscription, run *alertRun) bool {
+	_, v, ok := portfolioValue(sub)
+	if !ok || v == nil {
+		return ok
+	}
+	today := int(dayStart(time.Now().In(loadChatLocation(sub.ChatID))).Unix())
+	if sub.DayStart != today || sub.Baseline <= 0 {
+		run.update(func(s *Subscription) {
+			s.Baseline = v.Value
+			s.DayStart = today
+		})
+		return true
+	}
+	if change, alert := portfolioMove(sub, v.Value); alert {
+		lang := chatLang(sub.ChatID, nil)
+		msg := T(lang, "portfolio.fall", sub.Baseline, v.Value, change)
+		if change > 0 {
+			msg = T(lang, "portfolio.rise", sub.Baseline, v.Value, change)
+		}
+		log.Info(msg)
+		run.deliver(trendMark(change)+" "+escapeHTML(msg), true)
+		run.update(func(s *Subscription) { s.AlertedDay = today })
+	}
+	return true
+}
+
+func alertPortfolioDigest(k string, sub Subscription, run *alertRun) bool {
+	p, v, ok := portfolioValue(sub)
+	if !ok || v == nil {
+		return ok
+	}
+	lang := chatLang(sub.ChatID, nil)
+	msg := T(lang, "portfolio.digest", portfolioText(p, lang))
+	if sub.Baseline > 0 {
+		change := v.Value - sub.Baseline
+		msg = fmt.Sprintf("%s\n%s", msg, escapeHTML(T(lang, "portfolio.change", change, change/sub.Baseline*100, trendMark(change))))
+	}
+	run.deliver(msg, false)
+	//按设置的时间安排下一次, 重试或延迟执行不会让发送时间漂移
+	next := digestLastTime(time.Now().In(loadChatLocation(sub.ChatID)), sub.Hour, sub.Duration)
+	run.update(func(s *Subscription) {
+		s.Baseline = v.Value
+		s.LastTime = next
+	})
+	return true
+}
+
+//doPortfolioAlert /palert 50000 价位提醒, /palert 5% 当日涨跌提醒, /palert off 取消
+func doPortfolioAlert(message tb.Message, args []string) {
+	lang := messageLang(message)
+	uid := message.Sender.ID
+	chatID := message.Chat.ID
+	if len(args) == 0 {
+		bot.SendMessage(message.Chat, T(lang, "palert.usage"), nil)
+		return
+	}
+	if args[0] == "off" {
+		deleteSubscription(portfolioLevelKey(uid, chatID))
+		deleteSubscription(portfolioMoveKey(uid, chatID))
+		bot.SendMessage(message.Chat, T(lang, "palert.off"), nil)
+		return
+	}
+
+	percent := strings.HasSuffix(args[0], "%")
+	n, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+	if err != nil || n <= 0 {
+		bot.SendMessage(message.Chat, T(lang, "palert.usage"), nil)
+		return
+	}
+
+	ns := NewSubscription(PORTFOLIO, SubTypePortfolioLevel, portfolioCheckInterval)
+	ns.ChatID = chatID
+	ns.UserID = uid
+	key := portfolioLevelKey(uid, chatID)
+	msg := T(lang, "palert.level", n)
+	if percent {
+		ns.Type = SubTypePortfolioMove
+		key = portfolioMoveKey(uid, chatID)
+		msg = T(lang, "palert.move", n)
+		ns.Percent = n
+	} else {
+		ns.Level = n
+	}
+	//立即检查一次以记录当前总值
+	ns.LastTime = LocalSecond() - ns.Duration
+	addSubscription(key, ns)
+	log.Info(msg)
+	bot.SendMessage(message.Chat, msg, nil)
+}
+
+//doPortfolioDigest /pdigest on [hour] 每日持仓摘要, /pdigest off 取消
+func doPortfolioDigest(message tb.Message, args []string) {
+	lang := messageLang(message)
+	key := portfolioDigestKey(message.Sender.ID, message.Chat.ID)
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		bot.SendMessage(message.Chat, T(lang, "pdigest.usage"), nil)
+		return
+	}
+	if args[0] == "off" {
+		deleteSubscription(key)
+		bot.SendMessage(message.Chat, T(lang, "pdigest.off"), nil)
+		return
+	}
+
+	hour := portfolioDigestHour
+	if len(args) > 1 {
+		h, err := strconv.Atoi(args[1])
+		if err != nil || h < 0 || h > 23 {
+			bot.SendMessage(message.Chat, T(lang, "pdigest.usage"), nil)
+			return
+		}
+		hour = h
+	}
+
+	ns := NewSubscription(PORTFOLIO, SubTypePortfolioDigest, 86400)
+	ns.ChatID = message.Chat.ID
+	ns.UserID = message.Sender.ID
+	ns.Hour = hour
+	ns.LastTime = digestLastTime(time.Now().In(loadChatLocation(message.Chat.ID)), hour, ns.Duration)
+	addSubscription(key, ns)
+
+	msg := T(lang, "pdigest.on", hour)
+	log.Info(msg)
+	bot.SendMessage(message.Chat, msg, nil)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseHold(t *testing.T) {
+	symbol, amount, price, err := parseHold([]string{"btc", "0.5", "@", "30000"})
+	if err != nil || symbol != BTC || amount != 0.5 || price != 30000 {
+		t.Fatalf("got %s %v %v %v", symbol, amount, price, err)
+	}
+	if _, _, price, err = parseHold([]string{"ETH", "-2"}); err != nil || price != 0 {
+		t.Fatalf("price should be optional, got %v %v", price, err)
+	}
+	if _, _, _, err = parseHold([]string{"DOGE", "1"}); err == nil {
+		t.Fatal("unsupported symbol should fail")
+	}
+}
+
+func TestPortfolioValuate(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	p := NewPortfolio(7)
+	p.Add(BTC, 1, 30000)
+	p.Add(BTC, 1, 40000)
+	p.Add(ETH, 10, 200)
+	if err = p.Add(ETH, -20, 0); err == nil {
+		t.Fatal("selling more than held should fail")
+	}
+	p.Add(BTC, -0.5, 0)
+	if err = store.SavePortfolio(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = store.LoadPortfolio(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := p.Holdings[BTC]; h.Amount != 1.5 || h.Cost != 52500 {
+		t.Fatalf("unexpected BTC holding %+v", h)
+	}
+
+	v, err := p.Valuate(func(symbol string) (float64, error) {
+		return map[string]float64{BTC: 50000, ETH: 100}[symbol], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 76000 || v.Cost != 54500 || math.Abs(v.PLPercent()-39.45) > 0.01 {
+		t.Fatalf("unexpected valuation %+v", v)
+	}
+
+	if empty, _ := store.LoadPortfolio(8); len(empty.Holdings) != 0 {
+		t.Fatal("unknown user should have empty portfolio")
+	}
+}
+
+func TestMedian(t *testing.T) {
+	if median([]float64{3, 1, 2}) != 2 || median([]float64{4, 1, 3, 2}) != 2.5 {
+		t.Fatal("bad median")
+	}
+}
+
+func TestMedianPrice(t *testing.T) {
+	stable := func(asset string) (float64, error) {
+		if asset == USDT {
+			return 0.9, nil
+		}
+		return 0, errors.New("no price")
+	}
+	markets := []*Market{
+		{Quote: USD, Last: 100},
+		{Quote: USDT, Last: 110},
+		{Quote: USDT, Last: 120},
+		nil,
+	}
+	//USDT报价换算后为99和108
+	if p, err := medianPrice(BTC, markets, stable); err != nil || p != 100 {
+		t.Fatalf("median price should be normalized to USD, got %v %v", p, err)
+	}
+	if _, err := medianPrice(BTC, []*Market{{Quote: USDC, Last: 100}}, stable); err == nil {
+		t.Fatal("missing stablecoin rate should fail")
+	}
+	if _, err := medianPrice(BTC, []*Market{nil}, stable); err == nil {
+		t.Fatal("no market should fail")
+	}
+}
+
+func TestFetchMarketsPanic(t *testing.T) {
+	markets := fetchMarkets([]func() *Market{
+		func() *Market { return &Market{Last: 1} },
+		func() *Market { return []*Market{}[0] },
+	})
+	if markets[0] == nil || markets[1] != nil {
+		t.Fatalf("a panicking source should only fail itself, got %v", markets)
+	}
+}
+
+func TestPortfolioAlertHelpers(t *testing.T) {
+	if !crossed(49000, 51000, 50000) || !crossed(51000, 50000, 50000) || crossed(51000, 52000, 50000) {
+		t.Fatal("bad level crossing")
+	}
+
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2019, 1, 16, 10, 30, 0, 0, loc)
+	if next := nextDigestTime(now, 9); !next.Equal(time.Date(2019, 1, 17, 9, 0, 0, 0, loc)) {
+		t.Fatalf("unexpected digest time %v", next)
+	}
+	if next := nextDigestTime(now, 11); !next.Equal(time.Date(2019, 1, 16, 11, 0, 0, 0, loc)) {
+		t.Fatalf("unexpected digest time %v", next)
+	}
+	//重试或延迟后执行的摘要仍在第二天9点发送
+	late := time.Date(2019, 1, 16, 9, 0, 30, 0, loc)
+	if next := time.Unix(int64(digestLastTime(late, 9, 86400)+86400+1), 0); !next.Equal(time.Date(2019, 1, 17, 9, 0, 0, 0, loc)) {
+		t.Fatalf("digest should stay at 9:00, got %v", next.In(loc))
+	}
+	//UTC的1月15日20点在UTC+8已是1月16日
+	utc := time.Date(2019, 1, 15, 20, 0, 0, 0, time.UTC)
+	if start := dayStart(utc.In(loc)); !start.Equal(time.Date(2019, 1, 16, 0, 0, 0, 0, loc)) {
+		t.Fatalf("day should start in the chat time zone, got %v", start)
+	}
+
+	sub := Subscription{Percent: 5, Baseline: 100, DayStart: 1000}
+	if change, alert := portfolioMove(sub, 106); !alert || change != 6 {
+		t.Fatalf("move over percent should alert, got %v %v", change, alert)
+	}
+	if _, alert := portfolioMove(sub, 104); alert {
+		t.Fatal("move under percent should not alert")
+	}
+	//当日已提醒后基准不变, 不再重复提醒
+	sub.AlertedDay = sub.DayStart
+	if change, alert := portfolioMove(sub, 112); alert || change != 12 {
+		t.Fatalf("move should be measured from day start and alert once, got %v %v", change, alert)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//alertMessage 一次提醒中的一条消息, 内容为HTML
+type alertMessage struct {
+	Text   string
+	Urgent bool
+}
+
+//notify 发送订阅提醒, 免打扰期间非紧急提醒进入摘要, 紧急提醒照常通知
+func notify(chat *tb.Chat, key string, msg string, urgent bool) error {
+	return notifyAll(chat, key, []alertMessage{{msg, urgent}})
+}
+
+//notifyAll 发送一次提醒的全部消息, 不进入摘要的消息在同一事务内放入投递队列, 失败时一条也不会发出
+func notifyAll(chat *tb.Chat, key string, msgs []alertMessage) error {
+	var texts []string
+	for _, m := range msgs {
+		//摘要按key覆盖, 重试时再放入一次不会重复
+		if !m.Urgent && queueDigest(chat.ID, key, m.Text) {
+			continue
+		}
+		texts = append(texts, m.Text)
+	}
+	return outbox.SendHTML(chat.ID, texts...)
+}
+
+//queueDigest 免打扰期间把提醒放入摘要, 不在免打扰期间时不写入设置, 返回是否已放入
+func queueDigest(chatID int64, key string, msg string) bool {
+	s, err := chatSettings.LoadChatSettings(chatID)
+	if err != nil {
+		log.Error("load chat settings failed.", err)
+		return false
+	}
+	if !s.IsQuiet(time.Now()) {
+		return false
+	}
+	queued := false
+	_, err = chatSettings.UpdateChatSettings(chatID, func(s *ChatSettings) {
+		//读取后免打扰可能已结束, 在事务内再检查一次
+		if !s.IsQuiet(time.Now()) {
+			return
+		}
+		if s.Digest == nil {
+			s.Digest = make(map[string]string)
+		}
+		s.Digest[key] = msg
+		queued = true
+	})
+	if err != nil {
+		log.Error("save digest failed.", err)
+		return false
+	}
+	return queued
+}
+
+//takeDigest 取出已结束免打扰的聊天积压的提醒, 读取和清空在同一事务内
+func takeDigest(chatID int64, now time.Time) string {
+	var parts []string
+	_, err := chatSettings.UpdateChatSettings(chatID, func(s *ChatSettings) {
+		if len(s.Digest) == 0 || s.IsQuiet(now) {
+			return
+		}
+		keys := make([]string, 0, len(s.Digest))
+		for k := range s.Digest {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = append(parts, s.Digest[k])
+		}
+		s.Digest = nil
+	})
+	if err != nil {
+		log.Error("take digest failed.", err)
+		return ""
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+//flushDigest 免打扰结束后发送积压的提醒摘要
+func flushDigest() {
+	list, err := chatSettings.ListChatSettings()
+	if err != nil {
+		log.Error("list chat settings failed.", err)
+		return
+	}
+	now := time.Now()
+	for _, s := range list {
+		if len(s.Digest) == 0 || s.IsQuiet(now) {
+			continue
+		}
+		msg := takeDigest(s.ChatID, now)
+		if msg == "" {
+			continue
+		}
+		msg = T(chatLang(s.ChatID, nil), "quiet.digest", msg)
+		log.Info(msg)
+		if err := outbox.SendHTML(s.ChatID, msg); err != nil {
+			log.Error("queue digest failed.", err)
+		}
+	}
+}
+
+//parseHours 解析 "23-7" 或 "23 7" 格式的免打扰时段
+func parseHours(args []string) (int, int, error) {
+	if len(args) == 1 {
+		args = strings.Split(args[0], "-")
+	}
+	if len(args) != 2 {
+		return 0, 0, fmt.Errorf("bad quiet hours %v", args)
+	}
+	start, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || start > 23 || end < 0 || end > 23 {
+		return 0, 0, fmt.Errorf("bad quiet hours %d-%d", start, end)
+	}
+	return start, end, nil
+}
+
+//parseMute 解析 /mute 时长, 支持 30m 2h 1d
+func parseMute(arg string) (time.Duration, error) {
+	if strings.HasSuffix(arg, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(arg)
+}
+
+func doQuiet(chat *tb.Chat, lang string, args []string) {
+	msg := quietSetting(chat.ID, lang, args)
+	log.Info(msg)
+	bot.SendMessage(chat, msg, nil)
+}
+
+func quietSetting(chatID int64, lang string, args []string) string {
+	if len(args) == 0 {
+		q, err := chatSettings.LoadChatSettings(chatID)
+		if err != nil {
+			log.Error("load chat settings failed.", err)
+			return T(lang, "error.query")
+		}
+		msg := T(lang, "quiet.none")
+		if q.QuietStart != q.QuietEnd {
+			msg = T(lang, "quiet.hours", q.QuietStart, q.QuietEnd)
+		}
+		if q.MuteUntil > LocalSecond() {
+			msg = T(lang, "quiet.muted", msg, time.Unix(int64(q.MuteUntil), 0).In(q.Location()).Format("2006-01-02 15:04"))
+		}
+		return msg
+	}
+	start, end := 0, 0
+	reply := T(lang, "quiet.off")
+	if args[0] != "off" {
+		var err error
+		if start, end, err = parseHours(args); err != nil {
+			return T(lang, "quiet.usage")
+		}
+		reply = T(lang, "quiet.set", start, end)
+	}
+	if _, err := chatSettings.UpdateChatSettings(chatID, func(s *ChatSettings) { s.QuietStart, s.QuietEnd = start, end }); err != nil {
+		log.Error("save chat settings failed.", err)
+		return T(lang, "error.save")
+	}
+	return reply
+}
+
+func doMute(chat *tb.Chat, lang string, args []string) {
+	if len(args) == 0 {
+		bot.SendMessage(chat, T(lang, "mute.usage"), nil)
+		return
+	}
+	d, err := parseMute(args[0])
+	if err != nil || d <= 0 {
+		bot.SendMessage(chat, T(lang, "mute.usage"), nil)
+		return
+	}
+
+	until := time.Now().Add(d)
+	q, err := chatSettings.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.MuteUntil = int(until.Unix()) })
+	if err != nil {
+		log.Error("save chat settings failed.", err)
+		bot.SendMessage(chat, T(lang, "error.save"), nil)
+		return
+	}
+
+	msg := T(lang, "mute.set", until.In(q.Location()).Format("2006-01-02 15:04"))
+	log.Info(msg)
+	bot.SendMessage(chat, msg, nil)
+}
+
+func doUnmute(chat *tb.Chat, lang string) {
+	if _, err := chatSettings.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.MuteUntil = 0 }); err != nil {
+		log.Error("save chat settings failed.", err)
+		bot.SendMessage(chat, T(lang, "error.save"), nil)
+		return
+	}
+
+	bot.SendMessage(chat, T(lang, "mute.off"), nil)
+	flushDigest()
+}
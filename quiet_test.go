@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tb "tg.robot/telebot"
+)
+
+//useTestStore 把聊天设置和投递队列换成临时数据库, 测试结束后恢复
+func useTestStore(t *testing.T) *BoltStore {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSettings, oldOutbox := chatSettings, outbox
+	chatSettings = store
+	outbox = NewOutbox(store, func(msg *OutboundMessage) error { return nil }, nil, nil)
+	t.Cleanup(func() {
+		chatSettings, outbox = oldSettings, oldOutbox
+		store.Close()
+	})
+	return store
+}
+
+func TestNotifyOutsideQuietHours(t *testing.T) {
+	store := useTestStore(t)
+	if err := notify(&tb.Chat{ID: 42}, "BTC-42", "btc", false); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := store.ListChatSettings(); len(list) != 0 {
+		t.Fatalf("notify should not create chat settings, got %v", list)
+	}
+	if pending, _ := store.PendingMessages(); len(pending) != 1 || pending[0].Text != "btc" {
+		t.Fatalf("alert should be queued, got %v", pending)
+	}
+}
+
+//flakyStore 前fail次写入投递队列时失败
+type flakyStore struct {
+	*BoltStore
+	fail int
+}
+
+func (s *flakyStore) EnqueueMessages(msgs ...*OutboundMessage) error {
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("disk full")
+	}
+	return s.BoltStore.EnqueueMessages(msgs...)
+}
+
+func TestNotifyAllRetry(t *testing.T) {
+	store := useTestStore(t)
+	outbox = NewOutbox(&flakyStore{store, 1}, func(msg *OutboundMessage) error { return nil }, nil, nil)
+
+	msgs := []alertMessage{{"btc up", true}, {"btc report", false}}
+	if err := notifyAll(&tb.Chat{ID: 42}, "RANGE-42", msgs); err == nil {
+		t.Fatal("expected queue error")
+	}
+	if pending, _ := store.PendingMessages(); len(pending) != 0 {
+		t.Fatalf("failed alert should queue nothing, got %v", pending)
+	}
+	if err := notifyAll(&tb.Chat{ID: 42}, "RANGE-42", msgs); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := store.PendingMessages()
+	if len(pending) != 2 || pending[0].Text != "btc up" || pending[1].Text != "btc report" {
+		t.Fatalf("retry should queue every message once, got %v", pending)
+	}
+}
+
+func TestRunAlertRetry(t *testing.T) {
+	store := useTestStore(t)
+	flaky := &flakyStore{store, 1}
+	outbox = NewOutbox(flaky, func(msg *OutboundMessage) error { return nil }, nil, nil)
+	oldSubs, oldScheduler := subscriptions, scheduler
+	defer func() { subscriptions, scheduler = oldSubs, oldScheduler }()
+	var err error
+	if subscriptions, err = NewSubscriptionManager(newMemStore()); err != nil {
+		t.Fatal(err)
+	}
+	scheduler = NewScheduler(1, func(key string) {})
+
+	//测试用的提醒: 每次运行发一条消息并把基准价加1
+	const testType = 100
+	alertFuncs[testType] = func(k string, sub Subscription, run *alertRun) bool {
+		run.deliver("moved", true)
+		run.update(func(s *Subscription) { s.Baseline = sub.Baseline + 1 })
+		return true
+	}
+	defer delete(alertFuncs, testType)
+	ns := NewSubscription(BTC, testType, 600)
+	ns.ChatID = 42
+	ns.LastTime = 0
+	subscriptions.Add("TEST-42", ns)
+
+	runAlert("TEST-42")
+	if sub, _ := subscriptions.Get("TEST-42"); sub.Baseline != 0 || sub.LastTime != 0 {
+		t.Fatalf("failed alert should not change the subscription, got %+v", sub)
+	}
+	runAlert("TEST-42")
+	if sub, _ := subscriptions.Get("TEST-42"); sub.Baseline != 1 || sub.LastTime == 0 {
+		t.Fatalf("queued alert should update the subscription, got %+v", sub)
+	}
+	if pending, _ := store.PendingMessages(); len(pending) != 1 {
+		t.Fatalf("alert should be queued once, got %v", pending)
+	}
+}
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		args       []string
+		start, end int
+		ok         bool
+	}{
+		{[]string{"23-7"}, 23, 7, true},
+		{[]string{"23", "7"}, 23, 7, true},
+		{[]string{"0-8"}, 0, 8, true},
+		{[]string{"9-9"}, 9, 9, true},
+		{[]string{"24-7"}, 0, 0, false},
+		{[]string{"-1-7"}, 0, 0, false},
+		{[]string{"23"}, 0, 0, false},
+		{[]string{"a-b"}, 0, 0, false},
+		{[]string{"1", "2", "3"}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := parseHours(tt.args)
+		if (err == nil) != tt.ok || start != tt.start || end != tt.end {
+			t.Errorf("parseHours(%v) = %d, %d, %v", tt.args, start, end, err)
+		}
+	}
+}
+
+func TestParseMute(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want time.Duration
+		ok   bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"2h", 2 * time.Hour, true},
+		{"1d", 24 * time.Hour, true},
+		{"3d", 72 * time.Hour, true},
+		{"xd", 0, false},
+		{"2", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMute(tt.arg)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseMute(%q) = %v, %v", tt.arg, got, err)
+		}
+	}
+}
+
+func TestQuietWindow(t *testing.T) {
+	tests := []struct {
+		start, end, hour int
+		quiet            bool
+	}{
+		//跨午夜
+		{23, 7, 23, true},
+		{23, 7, 0, true},
+		{23, 7, 6, true},
+		{23, 7, 7, false},
+		{23, 7, 22, false},
+		{23, 7, 12, false},
+		//同一天内
+		{1, 8, 1, true},
+		{1, 8, 7, true},
+		{1, 8, 8, false},
+		{1, 8, 0, false},
+		//未设置
+		{5, 5, 5, false},
+	}
+	for _, tt := range tests {
+		s := &ChatSettings{Timezone: "UTC", QuietStart: tt.start, QuietEnd: tt.end}
+		now := time.Date(2019, 1, 16, tt.hour, 30, 0, 0, time.UTC)
+		if got := s.IsQuiet(now); got != tt.quiet {
+			t.Errorf("%02d-%02d at %02d:30 quiet=%v, want %v", tt.start, tt.end, tt.hour, got, tt.quiet)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	store := useTestStore(t)
+	chat := &tb.Chat{ID: 42}
+	store.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.MuteUntil = int(time.Now().Add(time.Hour).Unix()) })
+
+	notify(chat, "ETH-42", "eth 1", false)
+	notify(chat, "BTC-42", "btc 1", false)
+	notify(chat, "BTC-42", "btc 2", false)
+	notify(chat, "RANGE-42", "urgent", true)
+	if pending, _ := store.PendingMessages(); len(pending) != 1 || pending[0].Text != "urgent" {
+		t.Fatalf("only urgent alerts should be sent while muted, got %v", pending)
+	}
+	s, _ := store.LoadChatSettings(chat.ID)
+	if len(s.Digest) != 2 || s.Digest["BTC-42"] != "btc 2" {
+		t.Fatalf("digest should keep the latest alert per key, got %v", s.Digest)
+	}
+
+	//仍在免打扰期间时不发送
+	flushDigest()
+	if pending, _ := store.PendingMessages(); len(pending) != 1 {
+		t.Fatal("digest should wait for the end of quiet hours")
+	}
+
+	store.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.MuteUntil = 0 })
+	flushDigest()
+	pending, _ := store.PendingMessages()
+	if len(pending) != 2 || pending[1].Text != T(defaultLang, "quiet.digest", "btc 2\n\neth 1") {
+		t.Fatalf("unexpected digest %v", pending)
+	}
+	if s, _ = store.LoadChatSettings(chat.ID); len(s.Digest) != 0 {
+		t.Fatal("digest should be cleared after flushing")
+	}
+	flushDigest()
+	if pending, _ = store.PendingMessages(); len(pending) != 2 {
+		t.Fatal("digest should only be sent once")
+	}
+}
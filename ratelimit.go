@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	tb "tg.robot/telebot"
+)
+
+//rateLimitIdle 令牌桶超过该时间没有使用时清理, 此时必然已补满
+const rateLimitIdle = 10 * time.Minute
+
+//RateLimitConfig 令牌桶限制, 每分钟补充PerMinute次, 最多连续Burst次, PerMinute为0时不限制
+type RateLimitConfig struct {
+	PerMinute int `yaml:"per_minute"`
+	Burst     int `yaml:"burst"`
+}
+
+//unlimited 是否不限制
+func (c RateLimitConfig) unlimited() bool {
+	return c.PerMinute <= 0
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	//warned 本次冷却已提示过, 恢复可用后清除
+	warned bool
+}
+
+//RateLimiter 按用户和聊天限制命令频率, 机器人管理员和白名单聊天使用单独的限制
+type RateLimiter struct {
+	mu        sync.Mutex
+	user      RateLimitConfig
+	chat      RateLimitConfig
+	admin     RateLimitConfig
+	whitelist map[int64]bool
+	owners    map[int]bool
+	buckets   map[string]*bucket
+	pruned    time.Time
+}
+
+//NewRateLimiter create NewRateLimiter
+func NewRateLimiter(c *AppConfig) *RateLimiter {
+	l := &RateLimiter{buckets: make(map[string]*bucket)}
+	l.SetConfig(c)
+	return l
+}
+
+//SetConfig 更新限制, 已有的令牌桶保留
+func (l *RateLimiter) SetConfig(c *AppConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.user, l.chat, l.admin = c.UserLimit, c.ChatLimit, c.AdminLimit
+	l.whitelist = make(map[int64]bool, len(c.Whitelist))
+	for _, id := range c.Whitelist {
+		l.whitelist[id] = true
+	}
+	l.owners = make(map[int]bool, len(c.Owners))
+	for _, id := range c.Owners {
+		l.owners[id] = true
+	}
+}
+
+//take 从key的令牌桶取一个令牌, 不足时返回还需等待的时间, warn表示本次冷却第一次被拒绝
+func (l *RateLimiter) take(key string, limit RateLimitConfig, now time.Time) (ok bool, retry time.Duration, warn bool) {
+	if limit.unlimited() {
+		return true, 0, false
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	perToken := time.Minute / time.Duration(limit.PerMinute)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.at)) / float64(perToken)
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.at = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.warned = false
+		return true, 0, false
+	}
+	warn = !b.warned
+	b.warned = true
+	return false, time.Duration((1 - b.tokens) * float64(perToken)), warn
+}
+
+//prune 清理长时间没有使用的令牌桶
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < rateLimitIdle {
+		return
+	}
+	l.pruned = now
+	for k, b := range l.buckets {
+		if now.Sub(b.at) > rateLimitIdle {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+//Allow 检查消息的发送者和聊天是否超过限制, 超过时retry为需要等待的时间, warn为false时不必再提示
+func (l *RateLimiter) Allow(message tb.Message, now time.Time) (ok bool, retry time.Duration, warn bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	user, chat := l.user, l.chat
+	if l.whitelist[message.Chat.ID] || l.owners[message.Sender.ID] {
+		user, chat = l.admin, l.admin
+	}
+	if ok, retry, warn = l.take("u"+strconv.Itoa(message.Sender.ID), user, now); !ok {
+		return false, retry, warn
+	}
+	//私聊中用户限制已足够
+	if !message.Chat.IsGroupChat() {
+		return true, 0, false
+	}
+	return l.take("c"+strconv.FormatInt(message.Chat.ID, 10), chat, now)
+}
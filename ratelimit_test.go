@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tb "tg.robot/telebot"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(&AppConfig{
+		Owners:    []int{1},
+		Whitelist: []int64{-200},
+		UserLimit: RateLimitConfig{PerMinute: 6, Burst: 2},
+		ChatLimit: RateLimitConfig{PerMinute: 60, Burst: 3},
+	})
+	now := time.Now()
+	private := tb.Message{Sender: tb.User{ID: 42}, Chat: tb.Chat{ID: 42, Type: tb.ChatPrivate}}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(private, now); !ok {
+			t.Fatalf("burst request %d rejected", i)
+		}
+	}
+	ok, retry, warn := l.Allow(private, now)
+	if ok || !warn || retry != 10*time.Second {
+		t.Fatalf("third request ok=%v retry=%v warn=%v", ok, retry, warn)
+	}
+	if _, _, warn = l.Allow(private, now.Add(time.Second)); warn {
+		t.Fatal("cooldown should only be announced once")
+	}
+	if ok, _, _ = l.Allow(private, now.Add(10*time.Second)); !ok {
+		t.Fatal("token should refill after 10s")
+	}
+
+	//群组中每个用户各有2次, 群组共3次
+	group := tb.Chat{ID: -100, Type: "group"}
+	for i, id := range []int{2, 2, 3, 3} {
+		ok, _, _ = l.Allow(tb.Message{Sender: tb.User{ID: id}, Chat: group}, now)
+		if ok != (i < 3) {
+			t.Fatalf("group request %d ok=%v", i, ok)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if ok, _, _ = l.Allow(tb.Message{Sender: tb.User{ID: 1}, Chat: group}, now); !ok {
+			t.Fatal("owners are not limited by default")
+		}
+		if ok, _, _ = l.Allow(tb.Message{Sender: tb.User{ID: 4}, Chat: tb.Chat{ID: -200, Type: "group"}}, now); !ok {
+			t.Fatal("whitelisted chats are not limited by default")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+//ErrSubscriptionNotFound 订阅不存在
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+//subscriptionKey 订阅key, 格式为 类型-聊天ID
+func subscriptionKey(prefix string, chatID int64) string {
+	return fmt.Sprintf("%s-%d", prefix, chatID)
+}
+
+//SubscriptionManager 线程安全的订阅表, 命令处理和定时提醒都通过它读写订阅
+type SubscriptionManager struct {
+	mu    sync.RWMutex
+	subs  map[string]*Subscription
+	store SubscriptionStore
+}
+
+//NewSubscriptionManager 从store加载订阅
+func NewSubscriptionManager(store SubscriptionStore) (*SubscriptionManager, error) {
+	subs, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &SubscriptionManager{
+		subs:  subs,
+		store: store,
+	}, nil
+}
+
+//Add 添加或覆盖订阅
+func (m *SubscriptionManager) Add(key string, sub *Subscription) error {
+	cp := *sub
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.store.Save(key, &cp); err != nil {
+		return err
+	}
+	m.subs[key] = &cp
+	return nil
+}
+
+//Remove 删除订阅, 返回订阅是否存在
+func (m *SubscriptionManager) Remove(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subs[key]; !ok {
+		return false, nil
+	}
+	if err := m.store.Delete(key); err != nil {
+		return true, err
+	}
+	delete(m.subs, key)
+	return true, nil
+}
+
+//Get 返回订阅的副本
+func (m *SubscriptionManager) Get(key string) (Subscription, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sub, ok := m.subs[key]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *sub, true
+}
+
+//List 返回全部订阅的快照, 修改快照不影响订阅表
+func (m *SubscriptionManager) List() map[string]Subscription {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make(map[string]Subscription, len(m.subs))
+	for k, v := range m.subs {
+		list[k] = *v
+	}
+	return list
+}
+
+//Len 订阅数量
+func (m *SubscriptionManager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.subs)
+}
+
+//Update 在锁内修改订阅并持久化
+func (m *SubscriptionManager) Update(key string, fn func(sub *Subscription)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subs[key]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	cp := *sub
+	fn(&cp)
+	if err := m.store.Save(key, &cp); err != nil {
+		return err
+	}
+	m.subs[key] = &cp
+	return nil
+}
+
+//Rekey 群组升级为超级群组后, 把旧聊天ID的订阅全部迁移到新聊天ID, 返回迁移的key
+func (m *SubscriptionManager) Rekey(from int64, to int64) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	suffix := fmt.Sprintf("-%d", from)
+	moved := make(map[string]string)
+	for key, sub := range m.subs {
+		if sub.ChatID != from || !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		newKey := subscriptionKey(strings.TrimSuffix(key, suffix), to)
+		cp := *sub
+		cp.ChatID = to
+		cp.Disabled = false
+		if err := m.store.Move(key, newKey, &cp); err != nil {
+			return moved, err
+		}
+		delete(m.subs, key)
+		m.subs[newKey] = &cp
+		moved[key] = newKey
+	}
+	return moved, nil
+}
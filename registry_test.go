@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+//memStore 测试用的内存存储
+type memStore struct {
+	mu   sync.Mutex
+	subs map[string]Subscription
+}
+
+func newMemStore() *memStore {
+	return &memStore{subs: make(map[string]Subscription)}
+}
+
+func (s *memStore) Load() (map[string]*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make(map[string]*Subscription)
+	for k, v := range s.subs {
+		v := v
+		subs[k] = &v
+	}
+	return subs, nil
+}
+
+func (s *memStore) Save(key string, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[key] = *sub
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, key)
+	return nil
+}
+
+func (s *memStore) Move(from string, to string, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, from)
+	s.subs[to] = *sub
+	return nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func TestSubscriptionManager(t *testing.T) {
+	store := newMemStore()
+	m, err := NewSubscriptionManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := NewSubscription(BTC, 1, 3600)
+	sub.ChatID = 1
+	if err = m.Add("BTC-1", sub); err != nil {
+		t.Fatal(err)
+	}
+	sub.Duration = 1
+	if got, _ := m.Get("BTC-1"); got.Duration != 3600 {
+		t.Fatal("Add should copy the subscription")
+	}
+
+	if err = m.Update("BTC-1", func(s *Subscription) { s.LastTime = 42 }); err != nil {
+		t.Fatal(err)
+	}
+	if store.subs["BTC-1"].LastTime != 42 {
+		t.Fatal("Update should persist the subscription")
+	}
+	if err = m.Update("BCH-1", func(s *Subscription) {}); err != ErrSubscriptionNotFound {
+		t.Fatalf("Update of missing subscription returned %v", err)
+	}
+
+	if ok, _ := m.Remove("BTC-1"); !ok {
+		t.Fatal("Remove should report existing subscription")
+	}
+	if ok, _ := m.Remove("BTC-1"); ok {
+		t.Fatal("Remove should report missing subscription")
+	}
+	if m.Len() != 0 || len(store.subs) != 0 {
+		t.Fatal("subscription not removed")
+	}
+}
+
+func TestSubscriptionManagerRekey(t *testing.T) {
+	m, err := NewSubscriptionManager(newMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{BTC, BTC + BCH} {
+		sub := NewSubscription(BTC, 1, 3600)
+		sub.ChatID = -42
+		m.Add(subscriptionKey(prefix, -42), sub)
+	}
+	other := NewSubscription(BTC, 1, 3600)
+	other.ChatID = -4200
+	m.Add(subscriptionKey(BTC, -4200), other)
+
+	moved, err := m.Rekey(-42, -100123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 2 || moved["BTCBCH--42"] != "BTCBCH--100123" {
+		t.Fatalf("unexpected moved keys %v", moved)
+	}
+	if sub, ok := m.Get("BTC--100123"); !ok || sub.ChatID != -100123 {
+		t.Fatal("subscription not rekeyed")
+	}
+	if _, ok := m.Get("BTC--4200"); !ok {
+		t.Fatal("other chat should not be rekeyed")
+	}
+}
+
+//TestSubscriptionManagerRace 需配合 go test -race 运行
+func TestSubscriptionManagerRace(t *testing.T) {
+	m, err := NewSubscriptionManager(newMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("%s-%d", BTC, j%10)
+				if j%3 == 0 {
+					m.Remove(key)
+				} else {
+					m.Add(key, NewSubscription(BTC, 1, 3600))
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				for k, sub := range m.List() {
+					m.Update(k, func(s *Subscription) { s.LastTime = sub.LastTime + 1 })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
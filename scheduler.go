@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+//scheduledJob 调度队列中的一项, 按到期时间排序
+type scheduledJob struct {
+	key   string
+	due   time.Time
+	index int
+}
+
+type jobQueue []*scheduledJob
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	job := x.(*scheduledJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*q = old[:n-1]
+	return job
+}
+
+//Scheduler 按每个订阅的下次到期时间触发提醒, 到期任务交给固定数量的worker执行
+type Scheduler struct {
+	mu    sync.Mutex
+	queue jobQueue
+	jobs  map[string]*scheduledJob
+	wake  chan struct{}
+	//running 正在worker中执行的key
+	running map[string]bool
+	//deferred 执行期间到期的key, 执行结束后再放回队列
+	deferred map[string]time.Time
+
+	run     func(key string)
+	workers int
+}
+
+//NewScheduler create NewScheduler, run 在worker中执行, 同一个key不会并发执行
+func NewScheduler(workers int, run func(key string)) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Scheduler{
+		jobs:     make(map[string]*scheduledJob),
+		wake:     make(chan struct{}, 1),
+		running:  make(map[string]bool),
+		deferred: make(map[string]time.Time),
+		run:      run,
+		workers:  workers,
+	}
+}
+
+//Schedule 设置key的下次到期时间, 已在队列中则更新
+func (s *Scheduler) Schedule(key string, due time.Time) {
+	s.mu.Lock()
+	s.push(key, due)
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Scheduler) push(key string, due time.Time) {
+	if job, ok := s.jobs[key]; ok {
+		job.due = due
+		heap.Fix(&s.queue, job.index)
+	} else {
+		job = &scheduledJob{key: key, due: due}
+		heap.Push(&s.queue, job)
+		s.jobs[key] = job
+	}
+}
+
+//Cancel 从队列中移除key
+func (s *Scheduler) Cancel(key string) {
+	s.mu.Lock()
+	if job, ok := s.jobs[key]; ok {
+		heap.Remove(&s.queue, job.index)
+		delete(s.jobs, key)
+	}
+	delete(s.deferred, key)
+	s.mu.Unlock()
+	s.notify()
+}
+
+//Len 等待中的任务数, 包括等上一次执行结束的
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue) + len(s.deferred)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//next 取出已到期的任务并标记为执行中, 没有则返回距离下一个任务的等待时间
+//仍在执行的key到期时先移出队列, 等执行结束后再放回
+func (s *Scheduler) next(now time.Time) (string, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) > 0 {
+		job := s.queue[0]
+		if wait := job.due.Sub(now); wait > 0 {
+			return "", wait, false
+		}
+		heap.Pop(&s.queue)
+		delete(s.jobs, job.key)
+		if s.running[job.key] {
+			s.deferred[job.key] = job.due
+			continue
+		}
+		s.running[job.key] = true
+		return job.key, 0, true
+	}
+	return "", time.Hour, false
+}
+
+//done key执行结束, 执行期间到期的放回队列
+func (s *Scheduler) done(key string) {
+	s.mu.Lock()
+	delete(s.running, key)
+	due, ok := s.deferred[key]
+	if ok {
+		delete(s.deferred, key)
+		s.push(key, due)
+	}
+	s.mu.Unlock()
+	if ok {
+		s.notify()
+	}
+}
+
+//Start 启动调度和worker, 阻塞直到stop关闭
+func (s *Scheduler) Start(stop <-chan struct{}) {
+	due := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range due {
+				s.run(key)
+				s.done(key)
+			}
+		}()
+	}
+	defer func() {
+		close(due)
+		wg.Wait()
+	}()
+
+	for {
+		key, wait, ok := s.next(time.Now())
+		if ok {
+			select {
+			case due <- key:
+			case <-stop:
+				return
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
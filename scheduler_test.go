@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	var mu sync.Mutex
+	var fired []string
+	done := make(chan struct{})
+
+	s := NewScheduler(1, func(key string) {
+		mu.Lock()
+		fired = append(fired, key)
+		if len(fired) == 3 {
+			close(done)
+		}
+		mu.Unlock()
+	})
+
+	now := time.Now()
+	s.Schedule("c", now.Add(60*time.Millisecond))
+	s.Schedule("a", now.Add(20*time.Millisecond))
+	s.Schedule("b", now.Add(time.Hour))
+	s.Schedule("x", now)
+	s.Cancel("x")
+	//重新安排已在队列中的任务
+	s.Schedule("b", now.Add(40*time.Millisecond))
+
+	stop := make(chan struct{})
+	go s.Start(stop)
+	defer close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduler did not fire")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fired[0] != "a" || fired[1] != "b" || fired[2] != "c" {
+		t.Fatalf("fired in wrong order %v", fired)
+	}
+	if s.Len() != 0 {
+		t.Fatal("queue should be empty")
+	}
+}
+
+func TestSchedulerNoConcurrentKey(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive, calls := 0, 0, 0
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	s := NewScheduler(4, func(key string) {
+		mu.Lock()
+		active++
+		calls++
+		call := calls
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		if call == 1 {
+			<-release
+		}
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if call == 2 {
+			close(finished)
+		}
+	})
+
+	stop := make(chan struct{})
+	go s.Start(stop)
+	defer close(stop)
+
+	s.Schedule("a", time.Now())
+	time.Sleep(20 * time.Millisecond)
+	//第一次执行还没结束时再次到期
+	s.Schedule("a", time.Now())
+	time.Sleep(20 * time.Millisecond)
+	if s.Len() != 1 {
+		t.Fatalf("key should wait for the running call, len %d", s.Len())
+	}
+	close(release)
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("deferred key did not run")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive != 1 {
+		t.Fatalf("key ran concurrently %d times", maxActive)
+	}
+}
+
+func TestTickerCacheShared(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	c := NewTickerCache(time.Minute, func(url string) ([]byte, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return []byte(url), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, _ := c.Get("btcusd"); string(body) != "btcusd" {
+				t.Error("unexpected body")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("fetched %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+//ChatSettings 聊天设置, 按Chat.ID保存
+type ChatSettings struct {
+	ChatID int64
+	//Currency 行情显示币种, 空为交易所原始报价
+	Currency string
+	//Lang 回复语言, 空为按用户客户端语言
+	Lang string
+	//Timezone 时区(IANA名称), 空为服务器时区, 用于免打扰时段和时间显示
+	Timezone string
+	//Exchanges 行情对比显示的交易所, 空为全部
+	Exchanges []string
+	//Precision 价格小数位数, 0为自动(大于10两位, 否则四位)
+	Precision int
+	//QuietStart QuietEnd 免打扰时段(小时, 聊天时区), 相等表示未设置
+	QuietStart int
+	QuietEnd   int
+	//MuteUntil /mute 静音截止时间(秒)
+	MuteUntil int
+	//Digest 免打扰期间积压的提醒, key为订阅key, 只保留最新一条
+	Digest map[string]string
+	//AllowMembers 群组中所有成员都可以修改订阅和设置, 默认仅管理员
+	AllowMembers bool
+}
+
+//ChatSettingsStore 聊天设置持久化接口
+type ChatSettingsStore interface {
+	//LoadChatSettings 读取聊天设置, 不存在时返回默认设置
+	LoadChatSettings(chatID int64) (*ChatSettings, error)
+	SaveChatSettings(s *ChatSettings) error
+	//UpdateChatSettings 在同一事务内读取、修改并保存聊天设置
+	UpdateChatSettings(chatID int64, fn func(s *ChatSettings)) (*ChatSettings, error)
+	//ListChatSettings 全部保存过设置的聊天
+	ListChatSettings() ([]*ChatSettings, error)
+	//MoveChatSettings 群组升级为超级群组后把设置改存到新聊天
+	MoveChatSettings(from int64, to int64) error
+}
+
+var chatSettings ChatSettingsStore
+
+//Location 聊天时区, 未设置或无效时为服务器时区
+func (s *ChatSettings) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+//IsQuiet 当前是否处于免打扰状态
+func (s *ChatSettings) IsQuiet(now time.Time) bool {
+	if int(now.Unix()) < s.MuteUntil {
+		return true
+	}
+	if s.QuietStart == s.QuietEnd {
+		return false
+	}
+	h := now.In(s.Location()).Hour()
+	if s.QuietStart < s.QuietEnd {
+		return h >= s.QuietStart && h < s.QuietEnd
+	}
+	return h >= s.QuietStart || h < s.QuietEnd
+}
+
+//ShowExchange 行情对比是否显示该交易所
+func (s *ChatSettings) ShowExchange(name string) bool {
+	if len(s.Exchanges) == 0 {
+		return true
+	}
+	for _, e := range s.Exchanges {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+//FilterMarkets 只保留设置的交易所, 一个都不剩时返回全部
+func (s *ChatSettings) FilterMarkets(markets []*Market) []*Market {
+	filtered := make([]*Market, 0, len(markets))
+	for _, m := range markets {
+		if s.ShowExchange(m.Name) {
+			filtered = append(filtered, m)
+		}
+	}
+	if len(filtered) == 0 {
+		return markets
+	}
+	return filtered
+}
+
+//ToggleExchange 切换交易所是否显示, 全部显示时保存为空
+func (s *ChatSettings) ToggleExchange(name string) {
+	var next []string
+	for _, e := range exchangeNames {
+		show := s.ShowExchange(e)
+		if e == name {
+			show = !show
+		}
+		if show {
+			next = append(next, e)
+		}
+	}
+	if len(next) == len(exchangeNames) || len(next) == 0 {
+		next = nil
+	}
+	s.Exchanges = next
+}
+
+//loadChatLocation 聊天时区, 读取失败时为服务器时区
+func loadChatLocation(chatID int64) *time.Location {
+	s, err := chatSettings.LoadChatSettings(chatID)
+	if err != nil {
+		log.Error("load chat settings failed.", err)
+		return time.Local
+	}
+	return s.Location()
+}
+
+//settings菜单的回调, 除cbSettingsExchange外都没有参数
+const (
+	cbSettingsLang     = "sl"
+	cbSettingsTimezone = "sz"
+	cbSettingsCurrency = "sc"
+	cbSettingsDigits   = "sp"
+	cbSettingsQuiet    = "sq"
+	cbSettingsMembers  = "sm"
+	//cbSettingsExchange 切换交易所是否显示, 参数为交易所
+	cbSettingsExchange = "se"
+)
+
+//settings菜单中循环切换的选项, 空字符串为默认值
+var (
+	settingsTimezones  = []string{"", "UTC", "Asia/Shanghai", "Europe/London", "America/New_York"}
+	settingsCurrencies = []string{"", USD, "CNY", "EUR", USDT}
+	settingsPrecisions = []int{0, 2, 4, 6, 8}
+	settingsQuiet      = [][2]int{{0, 0}, {22, 7}, {23, 7}, {0, 8}}
+)
+
+//nextOption options中cur的下一个, cur不在其中时为第一个
+func nextOption(options []string, cur string) string {
+	for i, o := range options {
+		if o == cur {
+			return options[(i+1)%len(options)]
+		}
+	}
+	return options[0]
+}
+
+//settingsRow 菜单中的一项设置, Action为切换按钮的回调, 为空时没有按钮
+type settingsRow struct {
+	Label  string
+	Value  string
+	Action string
+}
+
+//settingsValues 菜单中显示的各项设置, 修改权限只在群组中显示
+func settingsValues(s *ChatSettings, lang string) []settingsRow {
+	value := func(v string, def string) string {
+		if v == "" {
+			return T(lang, def)
+		}
+		return v
+	}
+	precision := T(lang, "settings.auto")
+	if s.Precision > 0 {
+		precision = strconv.Itoa(s.Precision)
+	}
+	quiet := T(lang, "settings.off")
+	if s.QuietStart != s.QuietEnd {
+		quiet = fmt.Sprintf("%02d:00-%02d:00", s.QuietStart, s.QuietEnd)
+	}
+	rows := []settingsRow{
+		{T(lang, "settings.lang"), T(lang, "lang.name"), cbSettingsLang},
+		{T(lang, "settings.timezone"), value(s.Timezone, "settings.server"), cbSettingsTimezone},
+		{T(lang, "settings.currency"), value(s.Currency, "settings.raw"), cbSettingsCurrency},
+		{T(lang, "settings.precision"), precision, cbSettingsDigits},
+		{T(lang, "settings.quiet"), quiet, cbSettingsQuiet},
+	}
+	if s.ChatID < 0 {
+		members := T(lang, "settings.members.admins")
+		if s.AllowMembers {
+			members = T(lang, "settings.members.all")
+		}
+		rows = append(rows, settingsRow{T(lang, "settings.members"), members, cbSettingsMembers})
+	}
+	return append(rows, settingsRow{T(lang, "settings.exchanges"), value(strings.Join(s.Exchanges, ", "), "settings.all"), ""})
+}
+
+//settingsText 当前设置
+func settingsText(s *ChatSettings, lang string) string {
+	lines := []string{T(lang, "settings.title")}
+	for _, v := range settingsValues(s, lang) {
+		lines = append(lines, fmt.Sprintf("%s: %s", v.Label, v.Value))
+	}
+	return strings.Join(append(lines, "", T(lang, "settings.hint")), "\n")
+}
+
+//settingsActions 没有参数的回调
+var settingsActions = []string{cbSettingsLang, cbSettingsTimezone, cbSettingsCurrency, cbSettingsDigits, cbSettingsQuiet, cbSettingsMembers}
+
+//settingsKeyboard 每项设置一个切换按钮, 交易所按钮切换是否显示
+func settingsKeyboard(s *ChatSettings, lang string) [][]tb.KeyboardButton {
+	var keyboard [][]tb.KeyboardButton
+	for _, v := range settingsValues(s, lang) {
+		if v.Action != "" {
+			keyboard = append(keyboard, []tb.KeyboardButton{{Text: v.Label + ": " + v.Value, Data: callbacks.Data(v.Action)}})
+		}
+	}
+	var row []tb.KeyboardButton
+	for _, e := range exchangeNames {
+		mark := "⬜ "
+		if s.ShowExchange(e) {
+			mark = "✅ "
+		}
+		row = append(row, tb.KeyboardButton{Text: mark + e, Data: callbacks.Data(cbSettingsExchange, e)})
+		if len(row) == keyboardColumns {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+	return keyboard
+}
+
+//supportedCurrency 显示币种是否有汇率
+func supportedCurrency(currency string) bool {
+	if currency == "" || isStablecoin(currency) {
+		return true
+	}
+	_, err := rateSource().Rate(currency)
+	return err == nil
+}
+
+//nextCurrency 菜单中current之后第一个有汇率的币种, 查询汇率可能发出请求, 不要在事务内调用
+func nextCurrency(current string) string {
+	next := nextOption(settingsCurrencies, current)
+	for !supportedCurrency(next) {
+		next = nextOption(settingsCurrencies, next)
+	}
+	return next
+}
+
+//settingsToggles 菜单按钮对设置的修改
+var settingsToggles = map[string]func(s *ChatSettings, args []string){
+	cbSettingsLang: func(s *ChatSettings, args []string) {
+		lang := s.Lang
+		if lang == "" {
+			lang = defaultLang
+		}
+		s.Lang = nextOption([]string{LangZH, LangEN}, lang)
+	},
+	cbSettingsTimezone: func(s *ChatSettings, args []string) {
+		s.Timezone = nextOption(settingsTimezones, s.Timezone)
+	},
+	//cbSettingsCurrency 下一个币种由nextCurrency在事务外算出, 作为参数传入
+	cbSettingsCurrency: func(s *ChatSettings, args []string) {
+		s.Currency = args[0]
+	},
+	cbSettingsDigits: func(s *ChatSettings, args []string) {
+		next := settingsPrecisions[0]
+		for i, p := range settingsPrecisions {
+			if p == s.Precision {
+				next = settingsPrecisions[(i+1)%len(settingsPrecisions)]
+			}
+		}
+		s.Precision = next
+	},
+	cbSettingsQuiet: func(s *ChatSettings, args []string) {
+		next := settingsQuiet[0]
+		for i, q := range settingsQuiet {
+			if q[0] == s.QuietStart && q[1] == s.QuietEnd {
+				next = settingsQuiet[(i+1)%len(settingsQuiet)]
+			}
+		}
+		s.QuietStart, s.QuietEnd = next[0], next[1]
+	},
+	cbSettingsMembers: func(s *ChatSettings, args []string) {
+		s.AllowMembers = !s.AllowMembers
+	},
+	cbSettingsExchange: func(s *ChatSettings, args []string) {
+		for _, e := range exchangeNames {
+			if e == args[0] {
+				s.ToggleExchange(e)
+			}
+		}
+	},
+}
+
+//onSettings 修改设置并更新菜单
+func onSettings(action string) CallbackHandler {
+	return func(cb tb.Callback, args []string, response *tb.CallbackResponse) {
+		chat, lang, ok := priceMessage(cb, response)
+		if !ok || !callbackAllowed(cb, lang, response) {
+			return
+		}
+		if action == cbSettingsCurrency {
+			s, err := chatSettings.LoadChatSettings(chat.ID)
+			if err != nil {
+				log.Error("load chat settings failed.", err)
+				response.Text = T(lang, "error.query")
+				return
+			}
+			args = []string{nextCurrency(s.Currency)}
+		}
+		s, err := chatSettings.UpdateChatSettings(chat.ID, func(s *ChatSettings) { settingsToggles[action](s, args) })
+		if err != nil {
+			log.Error("save chat settings failed.", err)
+			response.Text = T(lang, "error.save")
+			return
+		}
+		lang = chatLang(chat.ID, &cb.Sender)
+		editAnswer(editHTML(chat, cb.Message.ID, escapeHTML(settingsText(s, lang)), settingsKeyboard(s, lang)), lang, response)
+	}
+}
+
+//doSettings /settings 显示设置菜单, /settings timezone Asia/Tokyo 设置菜单中没有的时区
+func doSettings(chat *tb.Chat, lang string, args []string) {
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != "timezone" {
+			bot.SendMessage(chat, T(lang, "settings.usage"), nil)
+			return
+		}
+		if _, err := time.LoadLocation(args[1]); err != nil || args[1] == "Local" {
+			bot.SendMessage(chat, T(lang, "settings.badtz", args[1]), nil)
+			return
+		}
+		if _, err := chatSettings.UpdateChatSettings(chat.ID, func(s *ChatSettings) { s.Timezone = args[1] }); err != nil {
+			log.Error("save chat settings failed.", err)
+			bot.SendMessage(chat, T(lang, "error.save"), nil)
+			return
+		}
+	}
+	s, err := chatSettings.LoadChatSettings(chat.ID)
+	if err != nil {
+		log.Error("load chat settings failed.", err)
+		bot.SendMessage(chat, T(lang, "error.query"), nil)
+		return
+	}
+	sendHTMLKeyboard(chat, escapeHTML(settingsText(s, lang)), settingsKeyboard(s, lang))
+}
+
+func init() {
+	for _, action := range settingsActions {
+		callbacks.Handle(action, 0, onSettings(action))
+	}
+	callbacks.Handle(cbSettingsExchange, 1, onSettings(cbSettingsExchange))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChatSettingsIsQuiet(t *testing.T) {
+	s := &ChatSettings{Timezone: "Asia/Shanghai", QuietStart: 23, QuietEnd: 7}
+	//UTC 16:00 为北京时间 00:00
+	if !s.IsQuiet(time.Date(2019, 1, 16, 16, 0, 0, 0, time.UTC)) {
+		t.Fatal("00:00 in Asia/Shanghai should be quiet")
+	}
+	if s.IsQuiet(time.Date(2019, 1, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("08:00 in Asia/Shanghai should not be quiet")
+	}
+	s = &ChatSettings{MuteUntil: int(time.Now().Add(time.Hour).Unix())}
+	if !s.IsQuiet(time.Now()) {
+		t.Fatal("muted chat should be quiet")
+	}
+	if s = (&ChatSettings{Timezone: "Nowhere/Bad"}); s.Location() != time.Local {
+		t.Fatal("bad timezone should fall back to server time")
+	}
+}
+
+func TestChatSettingsExchanges(t *testing.T) {
+	s := &ChatSettings{}
+	s.ToggleExchange(BITSTAMP)
+	if s.ShowExchange(BITSTAMP) || !s.ShowExchange(BINANCE) || len(s.Exchanges) != len(exchangeNames)-1 {
+		t.Fatalf("unexpected exchanges %v", s.Exchanges)
+	}
+	s.ToggleExchange(BITSTAMP)
+	if s.Exchanges != nil {
+		t.Fatalf("all exchanges should be saved as nil, got %v", s.Exchanges)
+	}
+
+	markets := []*Market{{Name: BITSTAMP}, {Name: BINANCE}}
+	s.Exchanges = []string{BINANCE}
+	if got := s.FilterMarkets(markets); len(got) != 1 || got[0].Name != BINANCE {
+		t.Fatalf("unexpected markets %v", got)
+	}
+	s.Exchanges = []string{COINEX}
+	if got := s.FilterMarkets(markets); len(got) != 2 {
+		t.Fatal("empty result should fall back to all markets")
+	}
+}
+
+func TestDisplayFormat(t *testing.T) {
+	var d *Display
+	if got := d.Format(12.3456); got != "12.35" {
+		t.Fatalf("auto precision got %q", got)
+	}
+	d = &Display{Raw: true, Precision: 6}
+	if got := d.Format(0.0123); got != "0.012300" {
+		t.Fatalf("fixed precision got %q", got)
+	}
+}
+
+func TestNextCurrency(t *testing.T) {
+	old := rateSource()
+	defer setRateSource(old)
+	setRateSource(StaticRateSource{"CNY": 7})
+
+	//没有汇率的EUR被跳过
+	for cur, want := range map[string]string{"": USD, USD: "CNY", "CNY": USDT, USDT: ""} {
+		if got := nextCurrency(cur); got != want {
+			t.Errorf("nextCurrency(%q) = %q, want %q", cur, got, want)
+		}
+	}
+}
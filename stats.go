@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+//providerFX 汇率接口在统计中的名称
+const providerFX = "FX"
+
+//providerHosts 接口域名对应的行情提供方
+var providerHosts = map[string]string{
+	"www.bitstamp.net": BITSTAMP,
+	"poloniex.com":     POLONIEX,
+	"bittrex.com":      BITTREX,
+	"api.bitfinex.com": BITFINEX,
+	"api.binance.com":  BINANCE,
+	"api.coinex.com":   COINEX,
+	"open.er-api.com":  providerFX,
+}
+
+//ProviderHealth 行情提供方的请求统计, 只统计实际发出的请求, 不包括缓存命中
+type ProviderHealth struct {
+	Name     string
+	Requests int
+	Errors   int
+	//Latency 全部请求的总耗时
+	Latency     time.Duration
+	LastOK      time.Time
+	LastError   string
+	LastErrorAt time.Time
+}
+
+//ErrorRate 失败比例
+func (p ProviderHealth) ErrorRate() float64 {
+	if p.Requests == 0 {
+		return 0
+	}
+	return float64(p.Errors) / float64(p.Requests)
+}
+
+//AvgLatency 平均耗时
+func (p ProviderHealth) AvgLatency() time.Duration {
+	if p.Requests == 0 {
+		return 0
+	}
+	return p.Latency / time.Duration(p.Requests)
+}
+
+//Up 最近一次请求是否成功
+func (p ProviderHealth) Up() bool {
+	return !p.LastOK.Before(p.LastErrorAt)
+}
+
+//CommandCount 命令调用次数
+type CommandCount struct {
+	Name  string
+	Count int
+}
+
+//Stats 运行统计, 只保存在内存中, 重启后清零
+type Stats struct {
+	mu        sync.Mutex
+	started   time.Time
+	commands  map[string]int
+	providers map[string]*ProviderHealth
+}
+
+//NewStats create NewStats
+func NewStats() *Stats {
+	return &Stats{
+		started:   time.Now(),
+		commands:  make(map[string]int),
+		providers: make(map[string]*ProviderHealth),
+	}
+}
+
+//Uptime 启动至今的时间
+func (s *Stats) Uptime() time.Duration {
+	return time.Since(s.started)
+}
+
+//Command 记录一次命令调用
+func (s *Stats) Command(name string) {
+	s.mu.Lock()
+	s.commands[name]++
+	s.mu.Unlock()
+}
+
+//Request 记录一次接口请求
+func (s *Stats) Request(provider string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.providers[provider]
+	if p == nil {
+		p = &ProviderHealth{Name: provider}
+		s.providers[provider] = p
+	}
+	p.Requests++
+	p.Latency += latency
+	if err != nil {
+		p.Errors++
+		p.LastError = err.Error()
+		p.LastErrorAt = time.Now()
+		return
+	}
+	p.LastOK = time.Now()
+}
+
+//Commands 按调用次数从多到少排列, 次数相同时按名称
+func (s *Stats) Commands() []CommandCount {
+	s.mu.Lock()
+	list := make([]CommandCount, 0, len(s.commands))
+	for name, n := range s.commands {
+		list = append(list, CommandCount{name, n})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+//Providers 各提供方统计的副本, 按名称排列
+func (s *Stats) Providers() []ProviderHealth {
+	s.mu.Lock()
+	list := make([]ProviderHealth, 0, len(s.providers))
+	for _, p := range s.providers {
+		list = append(list, *p)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+var stats = NewStats()
+
+//trackedGet 发出请求并按域名记录提供方的耗时和错误
+func trackedGet(rawurl string) ([]byte, error) {
+	start := time.Now()
+	body, err := httpGet(rawurl)
+	if u, perr := url.Parse(rawurl); perr == nil {
+		if name, ok := providerHosts[u.Host]; ok {
+			stats.Request(name, time.Since(start), err)
+		}
+	}
+	return body, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	s.Command("eth")
+	s.Command("btc")
+	s.Command("btc")
+	if list := s.Commands(); len(list) != 2 || list[0].Name != "btc" || list[0].Count != 2 {
+		t.Fatalf("unexpected commands %v", list)
+	}
+
+	s.Request(BINANCE, 100*time.Millisecond, nil)
+	s.Request(BINANCE, 300*time.Millisecond, errors.New("timeout"))
+	p := s.Providers()[0]
+	if p.ErrorRate() != 0.5 || p.AvgLatency() != 200*time.Millisecond || p.Up() || p.LastError != "timeout" {
+		t.Fatalf("unexpected health %+v", p)
+	}
+	s.Request(BINANCE, 100*time.Millisecond, nil)
+	if !s.Providers()[0].Up() {
+		t.Fatal("provider should be up after a successful request")
+	}
+}
+
+func TestTrackedGetStatus(t *testing.T) {
+	status := http.StatusTooManyRequests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"last":"1"}`))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	providerHosts[u.Host] = "test"
+	defer delete(providerHosts, u.Host)
+
+	c := NewTickerCache(time.Minute, trackedGet)
+	if _, err := c.Get(server.URL); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("non-2xx response should fail, got %v", err)
+	}
+	found := false
+	for _, p := range stats.Providers() {
+		if p.Name == "test" {
+			found = true
+			if p.Up() || !strings.Contains(p.LastError, "429") {
+				t.Fatalf("provider should be down with the status, got %+v", p)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("request should be tracked")
+	}
+
+	//失败的返回不缓存
+	status = http.StatusOK
+	if body, err := c.Get(server.URL); err != nil || string(body) != `{"last":"1"}` {
+		t.Fatalf("unexpected body %s %v", body, err)
+	}
+}
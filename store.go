@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	tb "tg.robot/telebot"
+)
+
+//SubscriptionStore 订阅持久化接口
+type SubscriptionStore interface {
+	//Load 读取全部订阅
+	Load() (map[string]*Subscription, error)
+	//Save 写入或覆盖一条订阅
+	Save(key string, sub *Subscription) error
+	//Delete 删除一条订阅
+	Delete(key string) error
+	//Move 在同一事务内把订阅从from改存到to
+	Move(from string, to string, sub *Subscription) error
+	Close() error
+}
+
+var (
+	bucketMeta          = []byte("meta")
+	bucketSubscriptions = []byte("subscriptions")
+	bucketOutbox        = []byte("outbox")
+	bucketDeadLetter    = []byte("deadletter")
+	bucketPortfolios    = []byte("portfolios")
+	bucketChats         = []byte("chats")
+	bucketConversations = []byte("conversations")
+
+	keySchemaVersion = []byte("schema_version")
+)
+
+//storeMigrations 数据库结构升级, 下标i表示从版本i升级到i+1
+var storeMigrations = []func(tx *bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSubscriptions)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketOutbox); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketDeadLetter)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketPortfolios)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketChats)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketConversations)
+		return err
+	},
+	renameSubscriptionFields,
+}
+
+//renameSubscriptionFields 订阅原来按Go字段名保存, 改为与导出文件一致的json字段名
+func renameSubscriptionFields(tx *bolt.Tx) error {
+	t := reflect.TypeOf(Subscription{})
+	b := tx.Bucket(bucketSubscriptions)
+	//ForEach中不能修改bucket, 先收集再写入
+	renamed := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(v, &fields); err != nil {
+			return errors.Wrapf(err, "bad subscription %s", k)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if raw, ok := fields[f.Name]; ok && name != f.Name {
+				delete(fields, f.Name)
+				fields[name] = raw
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		renamed[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, data := range renamed {
+		if err = b.Put([]byte(k), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//BoltStore 基于BoltDB的订阅存储
+type BoltStore struct {
+	db *bolt.DB
+}
+
+//OpenBoltStore 打开数据库并升级到最新版本
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open store %s failed", path)
+	}
+	s := &BoltStore{db: db}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//SchemaVersion 当前数据库结构版本
+func (s *BoltStore) SchemaVersion() (int, error) {
+	version := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func schemaVersion(tx *bolt.Tx) int {
+	meta := tx.Bucket(bucketMeta)
+	if meta == nil {
+		return 0
+	}
+	v := meta.Get(keySchemaVersion)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		version := schemaVersion(tx)
+		if version > len(storeMigrations) {
+			return errors.Errorf("store schema version %d is newer than supported %d", version, len(storeMigrations))
+		}
+		for ; version < len(storeMigrations); version++ {
+			if err = storeMigrations[version](tx); err != nil {
+				return errors.Wrapf(err, "migrate store to version %d failed", version+1)
+			}
+			log.Info("store migrated to schema version %d", version+1)
+		}
+		return meta.Put(keySchemaVersion, itob(uint64(version)))
+	})
+}
+
+//Load 读取全部订阅
+func (s *BoltStore) Load() (map[string]*Subscription, error) {
+	subs := make(map[string]*Subscription)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSubscriptions).ForEach(func(k, v []byte) error {
+			sub := new(Subscription)
+			if err := json.Unmarshal(v, sub); err != nil {
+				return errors.Wrapf(err, "bad subscription %s", k)
+			}
+			subs[string(k)] = sub
+			return nil
+		})
+	})
+	return subs, err
+}
+
+//Save 写入或覆盖一条订阅
+func (s *BoltStore) Save(key string, sub *Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSubscriptions).Put([]byte(key), data)
+	})
+}
+
+//Delete 删除一条订阅
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSubscriptions).Delete([]byte(key))
+	})
+}
+
+//Move 在同一事务内把订阅从from改存到to
+func (s *BoltStore) Move(from string, to string, sub *Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSubscriptions)
+		if err := b.Delete([]byte(from)); err != nil {
+			return err
+		}
+		return b.Put([]byte(to), data)
+	})
+}
+
+//Close 关闭数据库
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+//EnqueueMessages 在同一事务内写入消息并分配ID, 失败时一条也不写入
+func (s *BoltStore) EnqueueMessages(msgs ...*OutboundMessage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketOutbox)
+		for _, msg := range msgs {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			msg.ID = id
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(itob(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) loadMessages(bucket []byte) ([]*OutboundMessage, error) {
+	var msgs []*OutboundMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			msg := new(OutboundMessage)
+			if err := json.Unmarshal(v, msg); err != nil {
+				return errors.Wrapf(err, "bad outbox message %x", k)
+			}
+			msgs = append(msgs, msg)
+			return nil
+		})
+	})
+	return msgs, err
+}
+
+//PendingMessages 按ID顺序返回全部待投递消息
+func (s *BoltStore) PendingMessages() ([]*OutboundMessage, error) {
+	return s.loadMessages(bucketOutbox)
+}
+
+//UpdateMessage 更新待投递消息的重试信息
+func (s *BoltStore) UpdateMessage(msg *OutboundMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketOutbox).Put(itob(msg.ID), data)
+	})
+}
+
+//AckMessage 投递成功后删除消息
+func (s *BoltStore) AckMessage(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketOutbox).Delete(itob(id))
+	})
+}
+
+//BuryMessage 把消息移入死信列表
+func (s *BoltStore) BuryMessage(msg *OutboundMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketOutbox).Delete(itob(msg.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDeadLetter).Put(itob(msg.ID), data)
+	})
+}
+
+//DeadLetters 返回死信列表
+func (s *BoltStore) DeadLetters() ([]*OutboundMessage, error) {
+	return s.loadMessages(bucketDeadLetter)
+}
+
+//LoadPortfolio 读取用户持仓, 不存在时返回空持仓
+func (s *BoltStore) LoadPortfolio(userID int) (*Portfolio, error) {
+	p := NewPortfolio(userID)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketPortfolios).Get(itob(uint64(userID)))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, p)
+	})
+	if p.Holdings == nil {
+		p.Holdings = make(map[string]*Holding)
+	}
+	return p, err
+}
+
+//SavePortfolio 保存用户持仓
+func (s *BoltStore) SavePortfolio(p *Portfolio) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPortfolios).Put(itob(uint64(p.UserID)), data)
+	})
+}
+
+func getChatSettings(tx *bolt.Tx, chatID int64) (*ChatSettings, error) {
+	cs := &ChatSettings{ChatID: chatID}
+	v := tx.Bucket(bucketChats).Get(itob(uint64(chatID)))
+	if v == nil {
+		return cs, nil
+	}
+	err := json.Unmarshal(v, cs)
+	return cs, err
+}
+
+func putChatSettings(tx *bolt.Tx, cs *ChatSettings) error {
+	data, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketChats).Put(itob(uint64(cs.ChatID)), data)
+}
+
+//LoadChatSettings 读取聊天设置, 不存在时返回默认设置
+func (s *BoltStore) LoadChatSettings(chatID int64) (*ChatSettings, error) {
+	var cs *ChatSettings
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		cs, err = getChatSettings(tx, chatID)
+		return err
+	})
+	return cs, err
+}
+
+//SaveChatSettings 保存聊天设置
+func (s *BoltStore) SaveChatSettings(cs *ChatSettings) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putChatSettings(tx, cs)
+	})
+}
+
+//UpdateChatSettings 在同一事务内读取、修改并保存聊天设置
+func (s *BoltStore) UpdateChatSettings(chatID int64, fn func(cs *ChatSettings)) (*ChatSettings, error) {
+	var cs *ChatSettings
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if cs, err = getChatSettings(tx, chatID); err != nil {
+			return err
+		}
+		fn(cs)
+		return putChatSettings(tx, cs)
+	})
+	return cs, err
+}
+
+//ListChatSettings 全部保存过设置的聊天
+func (s *BoltStore) ListChatSettings() ([]*ChatSettings, error) {
+	var list []*ChatSettings
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketChats).ForEach(func(k, v []byte) error {
+			cs := &ChatSettings{}
+			if err := json.Unmarshal(v, cs); err != nil {
+				return err
+			}
+			list = append(list, cs)
+			return nil
+		})
+	})
+	return list, err
+}
+
+//MoveChatSettings 群组升级为超级群组后把设置改存到新聊天, 新聊天已有设置时保留新聊天的
+func (s *BoltStore) MoveChatSettings(from int64, to int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketChats)
+		if b.Get(itob(uint64(from))) == nil {
+			return nil
+		}
+		cs, err := getChatSettings(tx, from)
+		if err != nil {
+			return err
+		}
+		if err = b.Delete(itob(uint64(from))); err != nil {
+			return err
+		}
+		if b.Get(itob(uint64(to))) != nil {
+			return nil
+		}
+		cs.ChatID = to
+		return putChatSettings(tx, cs)
+	})
+}
+
+//LoadConversation 读取进行中的对话, 不存在时返回nil
+func (s *BoltStore) LoadConversation(chatID int64) (*Conversation, error) {
+	var c *Conversation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketConversations).Get(itob(uint64(chatID)))
+		if v == nil {
+			return nil
+		}
+		c = &Conversation{}
+		return json.Unmarshal(v, c)
+	})
+	return c, err
+}
+
+//SaveConversation 保存对话进度
+func (s *BoltStore) SaveConversation(c *Conversation) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketConversations).Put(itob(uint64(c.ChatID)), data)
+	})
+}
+
+//DeleteConversation 结束对话
+func (s *BoltStore) DeleteConversation(chatID int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketConversations).Delete(itob(uint64(chatID)))
+	})
+}
+
+//gobQuietHours 旧版 quiet.gob 中的免打扰设置格式
+type gobQuietHours struct {
+	ChatID    int64
+	Start     int
+	End       int
+	MuteUntil int
+	Digest    map[string]string
+}
+
+//ImportQuietGob 把旧版 quiet.gob 的免打扰设置合并到聊天设置, 成功后将文件改名为 .migrated
+func (s *BoltStore) ImportQuietGob(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	legacy := make(map[int64]*gobQuietHours)
+	err = gob.NewDecoder(file).Decode(&legacy)
+	file.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "decode %s failed", path)
+	}
+
+	count := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for id, q := range legacy {
+			if q == nil {
+				continue
+			}
+			cs, err := getChatSettings(tx, id)
+			if err != nil {
+				return err
+			}
+			cs.QuietStart, cs.QuietEnd, cs.MuteUntil = q.Start, q.End, q.MuteUntil
+			if len(q.Digest) > 0 {
+				cs.Digest = q.Digest
+			}
+			if err = putChatSettings(tx, cs); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = os.Rename(path, fmt.Sprintf("%s.migrated", path)); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+//gobSubscription 旧版 subscription.gob 中的订阅格式
+type gobSubscription struct {
+	Chat     *tb.Chat
+	Trader   string
+	Type     int
+	BCHPrice float64
+	BTCPrice float64
+	Duration int
+	LastTime int
+}
+
+//ImportGob 从旧版gob文件导入订阅, 导入在同一事务内完成, 成功后将文件改名为 .migrated
+func (s *BoltStore) ImportGob(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	legacy := make(map[string]*gobSubscription)
+	err = gob.NewDecoder(file).Decode(&legacy)
+	file.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "decode %s failed", path)
+	}
+
+	count := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSubscriptions)
+		for k, v := range legacy {
+			if v == nil || v.Chat == nil {
+				continue
+			}
+			sub := &Subscription{
+				ChatID:   v.Chat.ID,
+				Trader:   v.Trader,
+				Type:     v.Type,
+				BCHPrice: v.BCHPrice,
+				BTCPrice: v.BTCPrice,
+				Duration: v.Duration,
+				LastTime: v.LastTime,
+			}
+			data, err := json.Marshal(sub)
+			if err != nil {
+				return err
+			}
+			if err = b.Put([]byte(k), data); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = os.Rename(path, fmt.Sprintf("%s.migrated", path)); err != nil {
+		return count, err
+	}
+	return count, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	tb "tg.robot/telebot"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenBoltStore(filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if v, _ := store.SchemaVersion(); v != len(storeMigrations) {
+		t.Fatalf("schema version %d, want %d", v, len(storeMigrations))
+	}
+
+	sub := NewSubscription(BTC, 1, 3600)
+	sub.ChatID = -100
+	if err = store.Save("BTC--100", sub); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save("BCH--100", NewSubscription(BCH, 1, 3600)); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("BCH--100"); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs["BTC--100"].ChatID != -100 {
+		t.Fatalf("unexpected subscriptions %v", subs)
+	}
+}
+
+func TestBoltStoreRenameSubscriptionFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//旧版本按Go字段名保存的订阅
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		legacy := `{"ChatID":-100,"Trader":"BTC","Type":1,"Duration":3600,"LastTime":42,"BTCPrice":3500}`
+		if err := tx.Bucket(bucketSubscriptions).Put([]byte("BTC--100"), []byte(legacy)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMeta).Put(keySchemaVersion, itob(uint64(len(storeMigrations)-1)))
+	})
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store, err = OpenBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	subs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := subs["BTC--100"]; sub == nil || sub.ChatID != -100 || sub.LastTime != 42 || sub.BTCPrice != 3500 || sub.Trader != BTC {
+		t.Fatalf("legacy subscription not migrated: %+v", sub)
+	}
+}
+
+func TestBoltStoreImportGob(t *testing.T) {
+	dir := t.TempDir()
+	gobFile := filepath.Join(dir, "subscription.gob")
+
+	legacy := map[string]*gobSubscription{
+		"BTC-42":     {Chat: &tb.Chat{ID: 42, Type: tb.ChatPrivate}, Trader: BTC, Type: 1, Duration: 3600},
+		"BTCBCH-42":  {Chat: &tb.Chat{ID: 42}, Trader: BCH, Type: 2, Duration: 600, BTCPrice: 3500, BCHPrice: 120},
+		"BCH-broken": {Trader: BCH, Type: 1},
+	}
+	file, err := os.Create(gobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gob.NewEncoder(file).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	store, err := OpenBoltStore(filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	n, err := store.ImportGob(gobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("imported %d subscriptions, want 2", n)
+	}
+	if _, err = os.Stat(gobFile); !os.IsNotExist(err) {
+		t.Fatal("gob file should be renamed after import")
+	}
+
+	subs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := subs["BTCBCH-42"]; sub == nil || sub.ChatID != 42 || sub.BTCPrice != 3500 {
+		t.Fatalf("unexpected subscription %v", sub)
+	}
+}
+
+func TestBoltStoreChatSettings(t *testing.T) {
+	dir := t.TempDir()
+	gobFile := filepath.Join(dir, "quiet.gob")
+	legacy := map[int64]*gobQuietHours{
+		-100: {ChatID: -100, Start: 23, End: 7, Digest: map[string]string{"BTC--100": "btc"}},
+	}
+	file, err := os.Create(gobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gob.NewEncoder(file).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	store, err := OpenBoltStore(filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err = store.UpdateChatSettings(-100, func(s *ChatSettings) { s.Currency = "CNY" }); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.ImportQuietGob(gobFile); err != nil || n != 1 {
+		t.Fatalf("imported %d quiet hours %v", n, err)
+	}
+	s, err := store.LoadChatSettings(-100)
+	if err != nil || s.Currency != "CNY" || s.QuietStart != 23 || s.QuietEnd != 7 || s.Digest["BTC--100"] != "btc" {
+		t.Fatalf("merged settings %+v %v", s, err)
+	}
+
+	if err = store.MoveChatSettings(-100, -1001); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.ListChatSettings()
+	if err != nil || len(list) != 1 || list[0].ChatID != -1001 || list[0].Currency != "CNY" {
+		t.Fatalf("listed %v %v", list, err)
+	}
+}
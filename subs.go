@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+	tb "tg.robot/telebot"
+)
+
+//SubscriptionRecord 导出文件中的一条订阅
+type SubscriptionRecord struct {
+	Key          string `yaml:"key" json:"key"`
+	Subscription `yaml:",inline"`
+}
+
+//exportSubscriptions 按key排序导出订阅
+func exportSubscriptions(subs map[string]Subscription) []SubscriptionRecord {
+	keys := make([]string, 0, len(subs))
+	for k := range subs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	records := make([]SubscriptionRecord, 0, len(keys))
+	for _, k := range keys {
+		records = append(records, SubscriptionRecord{Key: k, Subscription: subs[k]})
+	}
+	return records
+}
+
+//subsFormat 根据参数或文件扩展名确定导出格式
+func subsFormat(format string, file string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yml", ".yaml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+	if format != "json" && format != "yaml" {
+		return "", errors.Errorf("unknown format %s, use json or yaml", format)
+	}
+	return format, nil
+}
+
+func encodeSubscriptions(w io.Writer, format string, records []SubscriptionRecord) error {
+	if format == "yaml" {
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func decodeSubscriptions(data []byte, format string) ([]SubscriptionRecord, error) {
+	var records []SubscriptionRecord
+	var err error
+	if format == "yaml" {
+		err = yaml.Unmarshal(data, &records)
+	} else {
+		err = json.Unmarshal(data, &records)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range records {
+		if r.Key == "" || r.ChatID == 0 {
+			return nil, errors.Errorf("record %d: key and chat id are required", i)
+		}
+		//key以聊天ID结尾, 不一致时迁移群组和删除提醒都会找不到这条订阅
+		prefix := strings.TrimSuffix(r.Key, fmt.Sprintf("-%d", r.ChatID))
+		if prefix == r.Key || prefix == "" || strings.HasSuffix(prefix, "-") {
+			return nil, errors.Errorf("record %d: key %s does not belong to chat %d", i, r.Key, r.ChatID)
+		}
+	}
+	return records, nil
+}
+
+//formatSubscriptions 订阅列表的文本形式, 用于 /subs 和 subs list
+func formatSubscriptions(subs map[string]Subscription) string {
+	records := exportSubscriptions(subs)
+	lines := make([]string, 0, len(records)+1)
+	lines = append(lines, fmt.Sprintf("subscriptions: %d", len(records)))
+	for _, r := range records {
+		state := ""
+		if r.Disabled {
+			state = " disabled"
+		}
+		lines = append(lines, fmt.Sprintf("%s chat=%d type=%d trader=%s every=%ds%s", r.Key, r.ChatID, r.Type, r.Trader, r.Duration, state))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//subsCommand tg.robot subs export|import|list
+func subsCommand(c *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tg.robot subs export|import|list [-format json|yaml] [file]")
+	}
+	fs := flag.NewFlagSet("subs "+args[0], flag.ContinueOnError)
+	format := fs.String("format", "", "json or yaml, default by file extension")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	file := fs.Arg(0)
+
+	store, err := OpenBoltStore(c.App.DB)
+	if err != nil {
+		return errors.Wrap(err, "is the bot still running?")
+	}
+	defer store.Close()
+	manager, err := NewSubscriptionManager(store)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		fmt.Println(formatSubscriptions(manager.List()))
+		return nil
+	case "export":
+		f, err := subsFormat(*format, file)
+		if err != nil {
+			return err
+		}
+		w := os.Stdout
+		if file != "" && file != "-" {
+			if w, err = os.Create(file); err != nil {
+				return err
+			}
+			defer w.Close()
+		}
+		return encodeSubscriptions(w, f, exportSubscriptions(manager.List()))
+	case "import":
+		f, err := subsFormat(*format, file)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if file == "" || file == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		records, err := decodeSubscriptions(data, f)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err = manager.Add(r.Key, &r.Subscription); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("imported %d subscriptions\n", len(records))
+		return nil
+	}
+	return errors.Errorf("unknown subs command %s", args[0])
+}
+
+//isOwner 是否为配置的机器人管理员
+func isOwner(user tb.User) bool {
+	for _, id := range currentConfig().App.Owners {
+		if id == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+//doSubs 管理员查看全部订阅
+func doSubs(message tb.Message) {
+	if !isOwner(message.Sender) {
+		bot.SendMessage(message.Chat, T(messageLang(message), "error.permission"), nil)
+		return
+	}
+	sendHTML(&message.Chat, preOpen+escapeHTML(formatSubscriptions(subscriptions.List()))+preClose)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSubscriptionExportRoundTrip(t *testing.T) {
+	subs := map[string]Subscription{
+		"BTC--42":    {ChatID: -42, Trader: BTC, Type: 1, Duration: 3600, LastTime: 100},
+		"BTCBCH-7":   {ChatID: 7, Trader: BCH, Type: 2, Duration: 600, BTCPrice: 3500.5, BCHPrice: 120},
+		"CoinEx-123": {ChatID: 123, Trader: COINEX, Type: 1, Duration: 3600, Disabled: true},
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		var buf bytes.Buffer
+		if err := encodeSubscriptions(&buf, format, exportSubscriptions(subs)); err != nil {
+			t.Fatal(err)
+		}
+		records, err := decodeSubscriptions(buf.Bytes(), format)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if len(records) != len(subs) {
+			t.Fatalf("%s: got %d records", format, len(records))
+		}
+		for _, r := range records {
+			if r.Subscription != subs[r.Key] {
+				t.Fatalf("%s: %s round trip got %+v", format, r.Key, r.Subscription)
+			}
+		}
+	}
+
+	if _, err := decodeSubscriptions([]byte(`[{"Trader":"BTC"}]`), "json"); err == nil {
+		t.Fatal("record without key should be rejected")
+	}
+
+	//json与yaml使用相同的字段名
+	var buf bytes.Buffer
+	if err := encodeSubscriptions(&buf, "json", exportSubscriptions(subs)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"key": "BTC--42"`)) || !bytes.Contains(buf.Bytes(), []byte(`"chat_id": -42`)) {
+		t.Fatalf("json should use the yaml field names: %s", buf.Bytes())
+	}
+
+	for _, data := range []string{
+		`[{"key":"BTC-42","chat_id":43}]`,
+		`[{"key":"BTC--42","chat_id":42}]`,
+		`[{"key":"-42","chat_id":-42}]`,
+	} {
+		if _, err := decodeSubscriptions([]byte(data), "json"); err == nil {
+			t.Fatalf("key not matching the chat should be rejected: %s", data)
+		}
+	}
+}
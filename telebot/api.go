@@ -16,6 +16,20 @@ import (
 	"github.com/pkg/errors"
 )
 
+// APIError is returned when Telegram reports a failed request.
+type APIError struct {
+	Code        int
+	Description string
+
+	// Optional. Details on how the request can be retried,
+	// e.g. the new chat ID after a group migration.
+	Parameters ResponseParameters
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error: %s", e.Description)
+}
+
 func wrapSystem(err error) error {
 	return errors.Wrap(err, "system error")
 }
@@ -119,7 +133,7 @@ func embedSendOptions(params map[string]string, options *SendOptions) {
 		forceReply := options.ReplyMarkup.ForceReply
 		customKeyboard := (options.ReplyMarkup.CustomKeyboard != nil)
 		inlineKeyboard := options.ReplyMarkup.InlineKeyboard != nil
-		hiddenKeyboard := options.ReplyMarkup.HideCustomKeyboard
+		hiddenKeyboard := options.ReplyMarkup.HideCustomKeyboard || options.ReplyMarkup.RemoveKeyboard
 		if forceReply || customKeyboard || hiddenKeyboard || inlineKeyboard {
 			replyMarkup, _ := json.Marshal(options.ReplyMarkup)
 			params["reply_markup"] = string(replyMarkup)
@@ -123,7 +123,9 @@ func (b *Bot) SendMessage(recipient Recipient, message string, options *SendOpti
 
 	var responseReceived struct {
 		Ok          bool
+		ErrorCode   int `json:"error_code"`
 		Description string
+		Parameters  ResponseParameters
 	}
 
 	err = json.Unmarshal(responseJSON, &responseReceived)
@@ -132,7 +134,52 @@ func (b *Bot) SendMessage(recipient Recipient, message string, options *SendOpti
 	}
 
 	if !responseReceived.Ok {
-		return errors.Errorf("api error: %s", responseReceived.Description)
+		return &APIError{
+			Code:        responseReceived.ErrorCode,
+			Description: responseReceived.Description,
+			Parameters:  responseReceived.Parameters,
+		}
+	}
+
+	return nil
+}
+
+// EditMessageText changes the text (and inline keyboard, if
+// options.ReplyMarkup has one) of a message previously sent by the bot.
+func (b *Bot) EditMessageText(recipient Recipient, messageID int, message string, options *SendOptions) error {
+	params := map[string]string{
+		"chat_id":    recipient.Destination(),
+		"message_id": strconv.Itoa(messageID),
+		"text":       message,
+	}
+
+	if options != nil {
+		embedSendOptions(params, options)
+	}
+
+	responseJSON, err := b.sendCommand("editMessageText", params)
+	if err != nil {
+		return err
+	}
+
+	var responseReceived struct {
+		Ok          bool
+		ErrorCode   int `json:"error_code"`
+		Description string
+		Parameters  ResponseParameters
+	}
+
+	err = json.Unmarshal(responseJSON, &responseReceived)
+	if err != nil {
+		return errors.Wrap(err, "bad response json")
+	}
+
+	if !responseReceived.Ok {
+		return &APIError{
+			Code:        responseReceived.ErrorCode,
+			Description: responseReceived.Description,
+			Parameters:  responseReceived.Parameters,
+		}
 	}
 
 	return nil
@@ -850,6 +897,34 @@ func (b *Bot) GetChatMember(recipient Recipient, user User) (ChatMember, error)
 	return responseReceived.Result, nil
 }
 
+// SetMyCommands changes the list of the bot's commands
+// shown in the client menu.
+func (b *Bot) SetMyCommands(commands []BotCommand) error {
+	params := struct {
+		Commands []BotCommand `json:"commands"`
+	}{commands}
+	responseJSON, err := b.sendCommand("setMyCommands", params)
+	if err != nil {
+		return err
+	}
+
+	var responseReceived struct {
+		Ok          bool
+		Description string
+	}
+
+	err = json.Unmarshal(responseJSON, &responseReceived)
+	if err != nil {
+		return errors.Wrap(err, "bad response json")
+	}
+
+	if !responseReceived.Ok {
+		return errors.Errorf("api error: %s", responseReceived.Description)
+	}
+
+	return nil
+}
+
 // GetFileDirectURL returns direct url for files using FileId which you can get from File object
 func (b *Bot) GetFileDirectURL(fileID string) (string, error) {
 	f, err := b.GetFile(fileID)
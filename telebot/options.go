@@ -46,6 +46,10 @@ type ReplyMarkup struct {
 	// Note: You dont need to set CustomKeyboard field to hide custom keyboard.
 	HideCustomKeyboard bool `json:"hide_keyboard,omitempty"`
 
+	// Requests clients to remove the custom keyboard (current name of
+	// HideCustomKeyboard in the Bot API).
+	RemoveKeyboard bool `json:"remove_keyboard,omitempty"`
+
 	// Use this param if you want to force reply from
 	// specific users only.
 	//
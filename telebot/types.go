@@ -84,6 +84,27 @@ type Update struct {
 	Query    *Query    `json:"inline_query"`
 }
 
+// ResponseParameters object describes why a request was unsuccessful.
+type ResponseParameters struct {
+	// Optional. The group has been migrated to a supergroup with
+	// the specified identifier.
+	MigrateTo int64 `json:"migrate_to_chat_id"`
+
+	// Optional. In case of exceeding flood control, the number of
+	// seconds left to wait before the request can be repeated.
+	RetryAfter int `json:"retry_after"`
+}
+
+// BotCommand represents a bot command shown in the client menu.
+type BotCommand struct {
+	// Text of the command, 1-32 characters. Can contain only
+	// lowercase English letters, digits and underscores.
+	Command string `json:"command"`
+
+	// Description of the command, 3-256 characters.
+	Description string `json:"description"`
+}
+
 // Thumbnail object represents an image/sticker of a particular size.
 type Thumbnail struct {
 	File
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//tickerCacheTTL 行情缓存时间, 同一时刻到期的订阅共用一次交易所查询
+const tickerCacheTTL = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+var tickers = NewTickerCache(tickerCacheTTL, trackedGet)
+
+//httpGet 非2xx的返回(限流、封禁、服务器错误)也算请求失败
+func httpGet(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("http status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type tickerEntry struct {
+	ready chan struct{}
+	body  []byte
+	err   error
+	at    time.Time
+}
+
+func (e *tickerEntry) expired(now time.Time, ttl time.Duration) bool {
+	select {
+	case <-e.ready:
+		return e.err != nil || now.Sub(e.at) > ttl
+	default:
+		//请求进行中, 等待结果即可
+		return false
+	}
+}
+
+//TickerCache 按url缓存交易所接口返回, 并合并同一url的并发请求
+type TickerCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*tickerEntry
+	fetch   func(url string) ([]byte, error)
+}
+
+//NewTickerCache create NewTickerCache
+func NewTickerCache(ttl time.Duration, fetch func(url string) ([]byte, error)) *TickerCache {
+	return &TickerCache{
+		ttl:     ttl,
+		entries: make(map[string]*tickerEntry),
+		fetch:   fetch,
+	}
+}
+
+//Get 返回url的内容, 缓存未过期时不发起请求
+func (c *TickerCache) Get(url string) ([]byte, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e := c.entries[url]
+	if e != nil && !e.expired(now, c.ttl) {
+		c.mu.Unlock()
+		<-e.ready
+		return e.body, e.err
+	}
+	for k, v := range c.entries {
+		if v.expired(now, c.ttl) {
+			delete(c.entries, k)
+		}
+	}
+	e = &tickerEntry{ready: make(chan struct{})}
+	c.entries[url] = e
+	c.mu.Unlock()
+
+	e.body, e.err = c.fetch(url)
+	e.at = time.Now()
+	close(e.ready)
+	return e.body, e.err
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/gonethopper/libs/logs"
+	tb "tg.robot/telebot"
+)
+
+const (
+	//conversationTimeout 超过该时间没有回复的对话作废
+	conversationTimeout = 10 * time.Minute
+	//wizardMinInterval 提醒的最短检查间隔
+	wizardMinInterval = time.Minute
+)
+
+//newalert对话的步骤
+const (
+	wizardPair = iota + 1
+	wizardType
+	wizardThreshold
+	wizardInterval
+)
+
+//wizardPairs 可选交易对, 每个切片为键盘的一行
+var wizardPairs = [][]string{{BTC, BCH, LTC, ETH}, {BCHBTC, LTCBTC, ETHBTC}}
+
+//wizardIntervals 可选间隔
+var wizardIntervals = []string{"10m", "30m", "1h", "4h", "1d"}
+
+//wizardTypes 可选提醒类型和消息目录中的名称
+var wizardTypes = []struct {
+	Type int
+	Key  string
+}{
+	{SubTypePriceLevel, "wizard.type.level"},
+	{SubTypePriceMove, "wizard.type.move"},
+	{SubTypeReport, "wizard.type.report"},
+}
+
+//Conversation 对话式设置的进度, 按Chat.ID保存, 只接受发起人的回复
+type Conversation struct {
+	ChatID    int64
+	UserID    int
+	Step      int
+	Pair      string
+	Type      int
+	Threshold float64
+	Interval  int
+	//Updated 最后一次回复的时间(秒)
+	Updated int
+}
+
+//ConversationStore 对话进度持久化接口
+type ConversationStore interface {
+	//LoadConversation 读取进行中的对话, 不存在时返回nil
+	LoadConversation(chatID int64) (*Conversation, error)
+	SaveConversation(c *Conversation) error
+	DeleteConversation(chatID int64) error
+}
+
+var conversations ConversationStore
+
+//Expired 对话是否已超时
+func (c *Conversation) Expired(now int) bool {
+	return now-c.Updated > int(conversationTimeout/time.Second)
+}
+
+//Advance 处理当前步骤的回复并进入下一步, 输入无效时返回false
+func (c *Conversation) Advance(text string, lang string) bool {
+	text = strings.TrimSpace(text)
+	switch c.Step {
+	case wizardPair:
+		pair := strings.ToUpper(text)
+		if _, ok := compareSources(pair); !ok {
+			return false
+		}
+		c.Pair = pair
+		c.Step = wizardType
+	case wizardType:
+		for _, t := range wizardTypes {
+			if text == T(lang, t.Key) {
+				c.Type = t.Type
+				c.Step = wizardThreshold
+				if t.Type == SubTypeReport {
+					c.Step = wizardInterval
+				}
+				return true
+			}
+		}
+		return false
+	case wizardThreshold:
+		n, err := strconv.ParseFloat(strings.TrimSuffix(text, "%"), 64)
+		if err != nil || n <= 0 {
+			return false
+		}
+		c.Threshold = n
+		c.Step = wizardInterval
+	case wizardInterval:
+		d, err := parseMute(text)
+		if err != nil || d < wizardMinInterval {
+			return false
+		}
+		c.Interval = int(d / time.Second)
+		c.Step = 0
+	default:
+		return false
+	}
+	return true
+}
+
+//Done 全部步骤已完成
+func (c *Conversation) Done() bool {
+	return c.Step == 0
+}
+
+//Subscription 对话完成后生成的订阅和它的key
+func (c *Conversation) Subscription() (string, *Subscription) {
+	ns := NewSubscription(c.Pair, c.Type, c.Interval)
+	ns.ChatID = c.ChatID
+	key := subscriptionKey(c.Pair, c.ChatID)
+	switch c.Type {
+	case SubTypePriceLevel:
+		key = subscriptionKey("LEVEL"+c.Pair, c.ChatID)
+		ns.Level = c.Threshold
+	case SubTypePriceMove:
+		key = subscriptionKey("MOVE"+c.Pair, c.ChatID)
+		ns.Percent = c.Threshold
+	}
+	if c.Type != SubTypeReport {
+		//立即检查一次以记录当前价格
+		ns.LastTime = LocalSecond() - ns.Duration
+	}
+	return key, ns
+}
+
+//formatInterval 间隔秒数显示为 30m 4h 1d
+func formatInterval(seconds int) string {
+	switch {
+	case seconds%86400 == 0:
+		return strconv.Itoa(seconds/86400) + "d"
+	case seconds%3600 == 0:
+		return strconv.Itoa(seconds/3600) + "h"
+	case seconds%60 == 0:
+		return strconv.Itoa(seconds/60) + "m"
+	}
+	return strconv.Itoa(seconds) + "s"
+}
+
+//keyboard 一次性的自定义键盘, 群组中只对发起人显示
+func keyboard(rows ...[]string) tb.ReplyMarkup {
+	return tb.ReplyMarkup{CustomKeyboard: rows, ResizeKeyboard: true, OneTimeKeyboard: true, Selective: true}
+}
+
+//wizardPrompt 当前步骤的提示和键盘
+func wizardPrompt(c *Conversation, lang string) (string, tb.ReplyMarkup) {
+	switch c.Step {
+	case wizardPair:
+		return T(lang, "wizard.pair"), keyboard(wizardPairs...)
+	case wizardType:
+		row := make([]string, 0, len(wizardTypes))
+		for _, t := range wizardTypes {
+			row = append(row, T(lang, t.Key))
+		}
+		return T(lang, "wizard.type", c.Pair), keyboard(row)
+	case wizardThreshold:
+		if c.Type == SubTypePriceMove {
+			return T(lang, "wizard.percent", c.Pair), tb.ReplyMarkup{ForceReply: true, Selective: true}
+		}
+		msg := T(lang, "wizard.level", c.Pair)
+		if price, err := referencePrice(c.Pair); err == nil {
+			msg = T(lang, "wizard.level.current", msg, formatPrice(price))
+		}
+		return msg, tb.ReplyMarkup{ForceReply: true, Selective: true}
+	}
+	return T(lang, "wizard.interval"), keyboard(wizardIntervals)
+}
+
+//wizardReply 回复发起人的消息, 使Selective键盘只对发起人显示
+func wizardReply(message tb.Message, text string, markup tb.ReplyMarkup) {
+	bot.SendMessage(message.Chat, text, &tb.SendOptions{ReplyTo: message, ReplyMarkup: markup})
+}
+
+//loadConversation 进行中且未超时的对话
+func loadConversation(chatID int64) (*Conversation, bool) {
+	c, err := conversations.LoadConversation(chatID)
+	if err != nil {
+		log.Error("load conversation failed.", err)
+		return nil, false
+	}
+	if c == nil {
+		return nil, false
+	}
+	if c.Expired(LocalSecond()) {
+		if err = conversations.DeleteConversation(chatID); err != nil {
+			log.Error("delete conversation failed.", err)
+		}
+		return nil, false
+	}
+	return c, true
+}
+
+//doNewAlert /newalert 逐步选择交易对、提醒类型、阈值和间隔
+func doNewAlert(message tb.Message, args []string) {
+	lang := messageLang(message)
+	c := &Conversation{ChatID: message.Chat.ID, UserID: message.Sender.ID, Step: wizardPair, Updated: LocalSecond()}
+	if err := conversations.SaveConversation(c); err != nil {
+		log.Error("save conversation failed.", err)
+		bot.SendMessage(message.Chat, T(lang, "error.save"), nil)
+		return
+	}
+	text, markup := wizardPrompt(c, lang)
+	wizardReply(message, text, markup)
+}
+
+//doCancel /cancel 取消进行中的对话
+func doCancel(message tb.Message, args []string) {
+	lang := messageLang(message)
+	if _, ok := loadConversation(message.Chat.ID); !ok {
+		bot.SendMessage(message.Chat, T(lang, "wizard.none"), nil)
+		return
+	}
+	if err := conversations.DeleteConversation(message.Chat.ID); err != nil {
+		log.Error("delete conversation failed.", err)
+	}
+	wizardReply(message, T(lang, "wizard.cancel"), tb.ReplyMarkup{RemoveKeyboard: true, Selective: true})
+}
+
+//continueConversation 把发起人的非命令消息交给进行中的对话, 已处理时返回true
+func continueConversation(message tb.Message) bool {
+	if strings.HasPrefix(message.Text, "/") || strings.TrimSpace(message.Text) == "" {
+		return false
+	}
+	c, ok := loadConversation(message.Chat.ID)
+	if !ok || c.UserID != message.Sender.ID {
+		return false
+	}
+	lang := messageLang(message)
+	if !c.Advance(message.Text, lang) {
+		text, markup := wizardPrompt(c, lang)
+		wizardReply(message, T(lang, "wizard.invalid", text), markup)
+		return true
+	}
+
+	if !c.Done() {
+		c.Updated = LocalSecond()
+		if err := conversations.SaveConversation(c); err != nil {
+			log.Error("save conversation failed.", err)
+		}
+		text, markup := wizardPrompt(c, lang)
+		wizardReply(message, text, markup)
+		return true
+	}
+
+	if err := conversations.DeleteConversation(c.ChatID); err != nil {
+		log.Error("delete conversation failed.", err)
+	}
+	key, ns := c.Subscription()
+	addSubscription(key, ns)
+	interval := formatInterval(c.Interval)
+	var msg string
+	switch c.Type {
+	case SubTypePriceLevel:
+		msg = T(lang, "wizard.done.level", c.Pair, formatPrice(c.Threshold), interval)
+	case SubTypePriceMove:
+		msg = T(lang, "wizard.done.move", c.Pair, c.Threshold, interval)
+	default:
+		msg = T(lang, "wizard.done.report", c.Pair, interval)
+	}
+	log.Info(msg)
+	wizardReply(message, msg, tb.ReplyMarkup{RemoveKeyboard: true, Selective: true})
+	return true
+}
+
+//cbAlertDelete 删除/alerts列出的提醒, 参数为订阅key
+const cbAlertDelete = "ad"
+
+//alertTypeKeys /alerts可以管理的提醒类型和消息目录中的格式
+var alertTypeKeys = map[int]string{
+	SubTypePriceLevel: "alerts.level",
+	SubTypePriceMove:  "alerts.move",
+	SubTypeReport:     "alerts.report",
+}
+
+//chatAlerts 聊天中/newalert和定时推送创建的提醒, 按key排列
+func chatAlerts(chatID int64) []SubscriptionRecord {
+	subs := make(map[string]Subscription)
+	for k, sub := range subscriptions.List() {
+		if _, ok := alertTypeKeys[sub.Type]; ok && sub.ChatID == chatID {
+			subs[k] = sub
+		}
+	}
+	return exportSubscriptions(subs)
+}
+
+//formatAlert 提醒的说明
+func formatAlert(sub Subscription, lang string) string {
+	interval := formatInterval(sub.Duration)
+	switch sub.Type {
+	case SubTypePriceLevel:
+		return T(lang, "alerts.level", sub.Trader, formatPrice(sub.Level), interval)
+	case SubTypePriceMove:
+		return T(lang, "alerts.move", sub.Trader, sub.Percent, interval)
+	}
+	return T(lang, "alerts.report", sub.Trader, interval)
+}
+
+//alertsMessage 提醒列表和删除按钮, 返回HTML
+func alertsMessage(chatID int64, lang string) (string, [][]tb.KeyboardButton) {
+	records := chatAlerts(chatID)
+	if len(records) == 0 {
+		return escapeHTML(T(lang, "alerts.none")), nil
+	}
+	lines := []string{escapeHTML(T(lang, "alerts.title"))}
+	var keyboard [][]tb.KeyboardButton
+	var row []tb.KeyboardButton
+	for i, r := range records {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, escapeHTML(formatAlert(r.Subscription, lang))))
+		row = append(row, tb.KeyboardButton{Text: fmt.Sprintf("✖ %d", i+1), Data: callbacks.Data(cbAlertDelete, r.Key)})
+		if len(row) == keyboardColumns {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+	return strings.Join(lines, "\n"), keyboard
+}
+
+//removeAlert 删除聊天中的提醒并取消定时, key不属于该聊天时返回false
+func removeAlert(chatID int64, key string) bool {
+	sub, ok := subscriptions.Get(key)
+	if _, managed := alertTypeKeys[sub.Type]; !ok || !managed || sub.ChatID != chatID {
+		return false
+	}
+	deleteSubscription(key)
+	return true
+}
+
+//doAlerts /alerts 列出本聊天的提醒, 点击按钮删除
+func doAlerts(message tb.Message, args []string) {
+	text, keyboard := alertsMessage(message.Chat.ID, messageLang(message))
+	sendHTMLKeyboard(&message.Chat, text, keyboard)
+}
+
+//onAlertDelete 删除提醒并更新列表
+func onAlertDelete(cb tb.Callback, args []string, response *tb.CallbackResponse) {
+	chat, lang, ok := priceMessage(cb, response)
+	if !ok || !callbackAllowed(cb, lang, response) {
+		return
+	}
+	if !removeAlert(chat.ID, args[0]) {
+		response.Text = T(lang, "error.callback")
+		return
+	}
+	log.Info("delete alert %s in %d", args[0], chat.ID)
+	text, keyboard := alertsMessage(chat.ID, lang)
+	if err := editHTML(chat, cb.Message.ID, text, keyboard); err != nil {
+		log.Error("edit message failed.", err)
+	}
+	response.Text = T(lang, "alerts.deleted")
+}
+
+func init() {
+	callbacks.Handle(cbAlertDelete, 1, onAlertDelete)
+}
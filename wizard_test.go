@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConversationAdvance(t *testing.T) {
+	c := &Conversation{ChatID: -100, UserID: 7, Step: wizardPair}
+	steps := []struct {
+		text string
+		ok   bool
+		step int
+	}{
+		{"doge", false, wizardPair},
+		{" ltcbtc ", true, wizardType},
+		{"Price", false, wizardType},
+		{"Price move", true, wizardThreshold},
+		{"-3", false, wizardThreshold},
+		{"2.5%", true, wizardInterval},
+		{"10s", false, wizardInterval},
+		{"4h", true, 0},
+	}
+	for _, s := range steps {
+		if ok := c.Advance(s.text, LangEN); ok != s.ok || c.Step != s.step {
+			t.Fatalf("Advance(%q) = %v, step %d, want %v step %d", s.text, ok, c.Step, s.ok, s.step)
+		}
+	}
+	key, sub := c.Subscription()
+	if key != "MOVELTCBTC--100" || sub.Type != SubTypePriceMove || sub.Percent != 2.5 || sub.Duration != 14400 || sub.Trader != LTCBTC {
+		t.Fatalf("subscription %s %+v", key, sub)
+	}
+
+	c = &Conversation{ChatID: 1, Step: wizardPair}
+	for _, text := range []string{"BTC", "定时推送", "1d"} {
+		if !c.Advance(text, LangZH) {
+			t.Fatalf("Advance(%q) failed at step %d", text, c.Step)
+		}
+	}
+	if key, sub = c.Subscription(); !c.Done() || key != "BTC-1" || sub.Type != SubTypeReport || formatInterval(sub.Duration) != "1d" {
+		t.Fatalf("report subscription %s %+v", key, sub)
+	}
+}
+
+func TestConversationStore(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if c, err := store.LoadConversation(5); err != nil || c != nil {
+		t.Fatalf("empty store returned %+v %v", c, err)
+	}
+	now := LocalSecond()
+	if err = store.SaveConversation(&Conversation{ChatID: 5, UserID: 9, Step: wizardThreshold, Pair: BTC, Updated: now}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.LoadConversation(5)
+	if err != nil || c.Step != wizardThreshold || c.Pair != BTC || c.UserID != 9 {
+		t.Fatalf("loaded %+v %v", c, err)
+	}
+	if c.Expired(now+60) || !c.Expired(now+int(conversationTimeout.Seconds())+1) {
+		t.Fatal("unexpected expiry")
+	}
+	if err = store.DeleteConversation(5); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = store.LoadConversation(5); c != nil {
+		t.Fatalf("deleted conversation loaded %+v", c)
+	}
+}
+
+func TestRemoveAlert(t *testing.T) {
+	oldSubs, oldScheduler := subscriptions, scheduler
+	defer func() { subscriptions, scheduler = oldSubs, oldScheduler }()
+	var err error
+	if subscriptions, err = NewSubscriptionManager(newMemStore()); err != nil {
+		t.Fatal(err)
+	}
+	scheduler = NewScheduler(1, func(key string) {})
+
+	c := &Conversation{ChatID: -100, Pair: ETH, Type: SubTypePriceMove, Threshold: 5, Interval: 600}
+	key, ns := c.Subscription()
+	addSubscription(key, ns)
+	range78 := subscriptionKey(BTC+BCH, -100)
+	addSubscription(range78, NewSubscription(BTC+BCH, SubTypeRange78, 600))
+	updateSubscription(range78, func(s *Subscription) { s.ChatID = -100 })
+	if records := chatAlerts(-100); len(records) != 1 || records[0].Key != key {
+		t.Fatalf("unexpected alerts %v", records)
+	}
+	if scheduler.Len() != 2 {
+		t.Fatalf("alerts should be scheduled, got %d", scheduler.Len())
+	}
+
+	if removeAlert(-200, key) || removeAlert(-100, range78) {
+		t.Fatal("only this chat's managed alerts can be removed")
+	}
+	if !removeAlert(-100, key) {
+		t.Fatal("remove alert failed")
+	}
+	if _, ok := subscriptions.Get(key); ok {
+		t.Fatal("subscription should be deleted")
+	}
+	if scheduler.Len() != 1 {
+		t.Fatalf("scheduler entry should be cancelled, got %d", scheduler.Len())
+	}
+}